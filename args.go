@@ -10,6 +10,13 @@ import (
 var (
 	tcpAddr  = flag.String("tcp", "", "tcp ip:port to listen on")
 	httpAddr = flag.String("http", "", "http ip:port to listen on")
+
+	// limits on the input that the reader will take, which keep a network
+	// client from crashing the process with input like a megabyte of open
+	// parens.  0 means no limit.
+	maxDepth = flag.Int("max-depth", 1000, "maximum nesting depth of a datum (0 for no limit)")
+	maxToken = flag.Int("max-token", 64<<10, "maximum length of a token, in bytes (0 for no limit)")
+	maxForm  = flag.Int("max-form", 1<<20, "maximum length of a top-level form, in bytes (0 for no limit)")
 )
 
 // executes a file on disk using the universe environment.  This will block
@@ -24,10 +31,20 @@ func runfile() {
 	}
 	defer f.Close()
 
-	i := newInterpreter(f, os.Stdout, os.Stderr)
+	i := newInterpreter(filename, f, os.Stdout, os.Stderr)
 	i.run(universe)
 }
 
+// checks whether f is a terminal, as opposed to a file or a pipe.  We only
+// show prompts when there's someone there to read them.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
 func printErrorMsg(message string) {
 	io.WriteString(os.Stderr, message)
 }
@@ -3,7 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
-	"reflect"
+	"github.com/jordanorelli/skeam/reader"
 )
 
 type builtin struct {
@@ -20,30 +20,33 @@ type builtin struct {
 	fn func([]interface{}) (interface{}, error)
 }
 
+func (b *builtin) String() string {
+	return "#<procedure " + b.name + ">"
+}
+
 // begins by evaluating all of its inputs.  An error on input evaluation will
 // stop evaluation of a builtin.  After evaluating its inputs, an arity check
 // is performed to see if the proper number of arguments have been supplied.
 // Perhaps this is the wrong order, I'm unsure.  Finally, the procudure is
 // passed the post-evaluation arguments to be executed.
-func (b builtin) call(env *environment, rawArgs []interface{}) (interface{}, error) {
-	// eval all arguments first
-	args := make([]interface{}, 0, len(rawArgs))
-	for _, raw := range rawArgs {
-		v, err := eval(raw, env)
-		if err != nil {
-			return nil, err
-		}
-		args = append(args, v)
-	}
+func (b *builtin) call(env *environment, rawArgs []interface{}) (interface{}, error) {
+	return evalArgs(rawArgs, env, b.invoke)
+}
 
-	if err := b.checkArity(len(rawArgs)); err != nil {
+// calls the builtin with arguments that have already been evaluated.
+func (b *builtin) invoke(args []interface{}) (interface{}, error) {
+	if err := b.checkArity(len(args)); err != nil {
 		return nil, err
 	}
 
 	return b.fn(args)
 }
 
-func (b builtin) checkArity(n int) error {
+func (b *builtin) apply(args []interface{}) (interface{}, error) {
+	return exec(b.invoke(args))
+}
+
+func (b *builtin) checkArity(n int) error {
 	if n == b.arity {
 		return nil
 	}
@@ -60,7 +63,7 @@ func (b builtin) checkArity(n int) error {
 	}
 }
 
-var add = builtin{
+var add = &builtin{
 	name:     "+",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -76,7 +79,7 @@ var add = builtin{
 	},
 }
 
-var sub = builtin{
+var sub = &builtin{
 	name:     "-",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -92,7 +95,7 @@ var sub = builtin{
 	},
 }
 
-var mul = builtin{
+var mul = &builtin{
 	name:     "*",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -110,7 +113,7 @@ var mul = builtin{
 	},
 }
 
-var div = builtin{
+var div = &builtin{
 	name:     "/",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -132,7 +135,7 @@ var div = builtin{
 	},
 }
 
-var not = builtin{
+var not = &builtin{
 	name:  "not",
 	arity: 1,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -140,48 +143,55 @@ var not = builtin{
 	},
 }
 
-var length = builtin{
+var length = &builtin{
 	name:  "length",
 	arity: 1,
 	fn: func(vals []interface{}) (interface{}, error) {
-		s, ok := vals[0].(*sexp)
-		if !ok {
-			return nil, fmt.Errorf("first argument must be sexp, received %v", reflect.TypeOf(vals[0]))
+		if !isList(vals[0]) {
+			return nil, fmt.Errorf("first argument must be a proper list, received %v", repr(vals[0]))
+		}
+		n := int64(0)
+		for v := vals[0]; v != null; v = v.(*pair).Cdr {
+			n++
 		}
-		return len(s.items), nil
+		return n, nil
 	},
 }
 
-var lst = builtin{
+var lst = &builtin{
 	name:     "list",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
-		return &sexp{items: vals, quotelvl: 1}, nil
+		return reader.ListFrom(vals, null), nil
 	},
 }
 
-var islist = builtin{
+var islist = &builtin{
 	name:  "list?",
 	arity: 1,
 	fn: func(vals []interface{}) (interface{}, error) {
-		_, ok := vals[0].(*sexp)
+		return isList(vals[0]), nil
+	},
+}
+
+var ispair = &builtin{
+	name:  "pair?",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		_, ok := vals[0].(*pair)
 		return ok, nil
 	},
 }
 
-var isnull = builtin{
+var isnull = &builtin{
 	name:  "null?",
 	arity: 1,
 	fn: func(vals []interface{}) (interface{}, error) {
-		s, ok := vals[0].(*sexp)
-		if !ok {
-			return false, nil
-		}
-		return len(s.items) == 0, nil
+		return vals[0] == null, nil
 	},
 }
 
-var issymbol = builtin{
+var issymbol = &builtin{
 	name:  "symbol?",
 	arity: 1,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -190,41 +200,69 @@ var issymbol = builtin{
 	},
 }
 
-var cons = builtin{
+var cons = &builtin{
 	name:  "cons",
 	arity: 2,
 	fn: func(vals []interface{}) (interface{}, error) {
-		s := &sexp{items: vals[0:1]}
-		switch t := vals[1].(type) {
-		case *sexp:
-			s.items = append(s.items, t.items...)
-		default:
-			s.items = append(s.items, t)
-		}
-		return s, nil
+		return &pair{Car: vals[0], Cdr: vals[1]}, nil
 	},
 }
 
-var car = builtin{
+// checks the type of the pair argument to the builtin named name.
+func pairArg(name string, v interface{}) (*pair, error) {
+	p, ok := v.(*pair)
+	if !ok {
+		return nil, fmt.Errorf("*%s* expects a pair, received %v", name, repr(v))
+	}
+	return p, nil
+}
+
+var car = &builtin{
 	name:  "car",
 	arity: 1,
 	fn: func(vals []interface{}) (interface{}, error) {
-		s, ok := vals[0].(*sexp)
-		if !ok {
-			return nil, errors.New("expected list")
+		p, err := pairArg("car", vals[0])
+		if err != nil {
+			return nil, err
 		}
-		return s.items[0], nil
+		return p.Car, nil
 	},
 }
 
-var cdr = builtin{
+var cdr = &builtin{
 	name:  "cdr",
 	arity: 1,
 	fn: func(vals []interface{}) (interface{}, error) {
-		s, ok := vals[0].(*sexp)
-		if !ok {
-			return nil, errors.New("expected list")
+		p, err := pairArg("cdr", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		return p.Cdr, nil
+	},
+}
+
+var setCar = &builtin{
+	name:  "set-car!",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		p, err := pairArg("set-car!", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		p.Car = vals[1]
+		return unspecified, nil
+	},
+}
+
+var setCdr = &builtin{
+	name:  "set-cdr!",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		p, err := pairArg("set-cdr!", vals[0])
+		if err != nil {
+			return nil, err
 		}
-		return s.items[1:], nil
+		p.Cdr = vals[1]
+		return unspecified, nil
 	},
 }
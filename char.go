@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ischar = &builtin{
+	name:  "char?",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		_, ok := vals[0].(char)
+		return ok, nil
+	},
+}
+
+var charToInteger = &builtin{
+	name:  "char->integer",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		c, ok := vals[0].(char)
+		if !ok {
+			return nil, fmt.Errorf("first argument must be char, received %v", reflect.TypeOf(vals[0]))
+		}
+		return int64(c), nil
+	},
+}
+
+var integerToChar = &builtin{
+	name:  "integer->char",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		n, ok := vals[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("first argument must be integer, received %v", reflect.TypeOf(vals[0]))
+		}
+		if n < 0 || n > utf8.MaxRune || !utf8.ValidRune(rune(n)) {
+			return nil, fmt.Errorf("%d is not a valid unicode scalar value", n)
+		}
+		return char(n), nil
+	},
+}
+
+// creates a builtin that maps one char to another
+func charMapper(name string, fn func(rune) rune) *builtin {
+	return &builtin{
+		name:  name,
+		arity: 1,
+		fn: func(vals []interface{}) (interface{}, error) {
+			c, ok := vals[0].(char)
+			if !ok {
+				return nil, fmt.Errorf("first argument must be char, received %v", reflect.TypeOf(vals[0]))
+			}
+			return char(fn(rune(c))), nil
+		},
+	}
+}
+
+// creates a builtin that tests whether a char belongs to some class of
+// characters
+func charClass(name string, fn func(rune) bool) *builtin {
+	return &builtin{
+		name:  name,
+		arity: 1,
+		fn: func(vals []interface{}) (interface{}, error) {
+			c, ok := vals[0].(char)
+			if !ok {
+				return nil, fmt.Errorf("first argument must be char, received %v", reflect.TypeOf(vals[0]))
+			}
+			return fn(rune(c)), nil
+		},
+	}
+}
+
+// creates a builtin that compares each char with the char to its right
+func charCmp(name string, fn func(char, char) bool) *builtin {
+	return &builtin{
+		name:     name,
+		arity:    2,
+		variadic: true,
+		fn: func(vals []interface{}) (interface{}, error) {
+			chars := make([]char, len(vals))
+			for i, v := range vals {
+				c, ok := v.(char)
+				if !ok {
+					return nil, fmt.Errorf("%s is not defined for %v", name, reflect.TypeOf(v))
+				}
+				chars[i] = c
+			}
+			for i := 1; i < len(chars); i++ {
+				if !fn(chars[i-1], chars[i]) {
+					return false, nil
+				}
+			}
+			return true, nil
+		},
+	}
+}
+
+var (
+	charUpcase       = charMapper("char-upcase", unicode.ToUpper)
+	charDowncase     = charMapper("char-downcase", unicode.ToLower)
+	isCharAlphabetic = charClass("char-alphabetic?", unicode.IsLetter)
+	isCharNumeric    = charClass("char-numeric?", unicode.IsDigit)
+	charEquals       = charCmp("char=?", func(x, y char) bool { return x == y })
+	charLess         = charCmp("char<?", func(x, y char) bool { return x < y })
+)
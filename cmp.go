@@ -60,7 +60,7 @@ func cmp_left(vals []interface{}, fni cmp_bin_i, fnf cmp_bin_f) (bool, error) {
 	return true, nil
 }
 
-var gt = builtin{
+var gt = &builtin{
 	name:     ">",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -70,7 +70,7 @@ var gt = builtin{
 	},
 }
 
-var gte = builtin{
+var gte = &builtin{
 	name:     ">=",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -80,7 +80,7 @@ var gte = builtin{
 	},
 }
 
-var lt = builtin{
+var lt = &builtin{
 	name:     "<",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -90,7 +90,7 @@ var lt = builtin{
 	},
 }
 
-var lte = builtin{
+var lte = &builtin{
 	name:     "<=",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -100,7 +100,7 @@ var lte = builtin{
 	},
 }
 
-var equals = builtin{
+var equals = &builtin{
 	name:     "=",
 	variadic: true,
 	fn: func(vals []interface{}) (interface{}, error) {
@@ -109,3 +109,26 @@ var equals = builtin{
 		return cmp_left(vals, fni, fnf)
 	},
 }
+
+// whether a and b are the same value, in the sense of eqv?.  Numbers,
+// characters, booleans, symbols and strings are the same as anything of the
+// same type with the same value, while pairs, vectors, hash tables and
+// procedures are only the same as themselves.
+func eqv(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+var isEqv = &builtin{
+	name:  "eqv?",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		return eqv(vals[0], vals[1]), nil
+	},
+}
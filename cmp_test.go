@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEqv(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"numbers", "(list (eqv? 1 1) (eqv? 1 1.0) (eqv? 2.5 2.5))", "(#t #f #t)"},
+		{"pairs", "(define p (list 1)) (list (eqv? p p) (eqv? (list 1) (list 1)))", "(#t #f)"},
+		{"lambdas", `
+			(define f (lambda (x) x))
+			(define g (lambda (x) x))
+			(list (eqv? f f) (eqv? f g))`,
+			"(#t #f)"},
+		{"builtins", "(list (eqv? car car) (eqv? car cdr) (eqv? read-char peek-char))", "(#t #f #f)"},
+		{"case-lambda", `
+			(define h (case-lambda ((x) x) ((x y) y)))
+			(list (eqv? h h) (eqv? h (case-lambda ((x) x))))`,
+			"(#t #f)"},
+		{"continuations", "(let ((k #f)) (call/cc (lambda (c) (set! k c))) (eqv? k k))", "#t"},
+	})
+}
+
+func TestEqual(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"structures", "(list (equal? (list 1 #(2 \"3\")) (list 1 #(2 \"3\"))) (equal? (list 1) (list 1.0)))", "(#t #f)"},
+		{"procedures", `
+			(define f (lambda (x) x))
+			(list (equal? f f) (equal? f (lambda (x) x)) (equal? car car) (equal? (list car) (list car)))`,
+			"(#t #f #t #t)"},
+		{"procedures as keys", `
+			(define f (lambda (x) x))
+			(define g (lambda (x) x))
+			(define t (make-hash-table))
+			(hash-table-set! t f 'f)
+			(hash-table-set! t g 'g)
+			(hash-table-set! t car 'car)
+			(list (hash-table-ref/default t f #f) (hash-table-ref/default t g #f)
+			      (hash-table-ref/default t car #f) (hash-table-ref/default t cdr #f)
+			      (hash-table-size t))`,
+			"(f g car #f 3)"},
+	})
+}
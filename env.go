@@ -11,9 +11,27 @@ func (u UnknownSymbolError) Error() string {
 	return fmt.Sprintf(`unknown symbol "%v"`, u.symbol)
 }
 
+// type unassignedValue is the value of a variable that exists but hasn't been
+// defined yet, like one defined inside of a lambda body before its define has
+// been evaluated.
+type unassignedValue struct{}
+
+var unassigned = unassignedValue{}
+
+type UnassignedSymbolError struct{ symbol }
+
+func (u UnassignedSymbolError) Error() string {
+	return fmt.Sprintf(`symbol "%v" used before it was defined`, u.symbol)
+}
+
 type environment struct {
 	items map[symbol]interface{}
 	outer *environment
+
+	// the ids of the macros that were defined in this environment.  An alias
+	// made by one of them that isn't bound anywhere stands for the symbol that
+	// it was made from, as seen from here.
+	macros map[uint64]bool
 }
 
 func newEnvironment(outer *environment) *environment {
@@ -26,21 +44,67 @@ func newEnvironment(outer *environment) *environment {
 func (e environment) get(key symbol) (interface{}, error) {
 	v, ok := e.items[key]
 	if ok {
-		debugPrint(fmt.Sprintf(`found key "%v": %v`, key, v))
+		if v == unassigned {
+			return nil, UnassignedSymbolError{key}
+		}
+		if DEBUG {
+			debugPrint(fmt.Sprintf(`found key "%v": %v`, key, v))
+		}
 		return v, nil
 	}
 
+	if orig, ok := e.resolve(key); ok {
+		return e.get(orig)
+	}
+
 	if e.outer != nil {
 		return e.outer.get(key)
 	}
 
-	return nil, UnknownSymbolError{key}
+	return nil, UnknownSymbolError{plain(key)}
 }
 
 func (e environment) set(key symbol, val interface{}) {
 	e.items[key] = val
 }
 
+// changes the value of key in the frame that defines it, which may be e or
+// any of the frames outside of it.  Unlike set, update never creates a new
+// binding, so it can't shadow a binding from an outer frame.
+func (e environment) update(key symbol, val interface{}) error {
+	for f := &e; f != nil; f = f.outer {
+		if _, ok := f.items[key]; ok {
+			f.items[key] = val
+			return nil
+		}
+		if orig, ok := f.resolve(key); ok {
+			return f.update(orig, val)
+		}
+	}
+	return UnknownSymbolError{plain(key)}
+}
+
+// records that the macro m was defined in e.
+func (e *environment) addMacro(m *syntaxRules) {
+	if e.macros == nil {
+		e.macros = make(map[uint64]bool)
+	}
+	e.macros[m.id] = true
+}
+
+// the symbol that key stands for in e, if key is an alias made by a macro
+// that was defined in e.
+func (e environment) resolve(key symbol) (symbol, bool) {
+	if len(e.macros) == 0 {
+		return key, false
+	}
+	orig, id, ok := parseAlias(key)
+	if !ok || !e.macros[id] {
+		return key, false
+	}
+	return orig, true
+}
+
 func (e environment) keys() []string {
 	keys := make([]string, 0, len(e.items))
 	for key, _ := range e.items {
@@ -55,5 +119,8 @@ func (e environment) keys() []string {
 
 func (e environment) defined(key symbol) bool {
 	_, err := e.get(key)
+	if _, ok := err.(UnassignedSymbolError); ok {
+		return true
+	}
 	return err == nil
 }
@@ -3,75 +3,123 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"github.com/jordanorelli/skeam/reader"
 	"io"
 )
 
-func eval(v interface{}, env *environment) (interface{}, error) {
-	if v == nil {
-		return &sexp{}, nil
-	}
+// type sourceError is an error that can be traced back to some position in
+// the source input.
+type sourceError struct {
+	pos position
+	err error
+}
 
-	switch t := v.(type) {
-	case symbol:
-		return t.eval(env)
-	case *sexp:
-		return t.eval(env)
-	default:
-		debugPrint("default eval")
-		return v, nil
-	}
+func (e sourceError) Error() string {
+	return fmt.Sprintf("%v: %v", e.pos, e.err)
+}
 
-	panic("not reached")
+// annotates an error with the position p.  Errors that already have a
+// position keep it, since the innermost position is the most useful one.
+func errorAt(p position, err error) error {
+	if err == nil || !p.IsValid() {
+		return err
+	}
+	switch err.(type) {
+	case sourceError, reader.Error, escape:
+		return err
+	}
+	return sourceError{p, err}
 }
 
 type interpreter struct {
-	in     io.Reader        // reader of input source code
-	out1   io.Writer        // writer of evaluated values
-	out2   io.Writer        // writer of error info
-	tokens chan token       // tokens returns from the lexer (internal only)
-	values chan interface{} // values returned from the interpreter (internal only)
-	errors chan error       // errors returned from the interpreter (internal only)
+	name   string      // name of the input, used in error positions
+	in     io.Reader   // reader of input source code
+	out1   io.Writer   // writer of evaluated values
+	out2   io.Writer   // writer of error info
+	values chan string // printed values returned from the interpreter (internal only)
+	errors chan error  // errors returned from the interpreter (internal only)
+	output chan string // text written out by the program, e.g. with display (internal only)
+	prompt chan string // prompts to show before reading more input (internal only)
+	done   chan bool   // signals that there's nothing left to send (internal only)
+
+	session *session // the output and reader macros of the session
+}
+
+// type outputWriter hands whatever is written to it over to send, so that
+// the program's output and its values come out in the order they were made.
+type outputWriter chan string
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
 }
 
-func newInterpreter(in io.Reader, out1, out2 io.Writer) *interpreter {
+func newInterpreter(name string, in io.Reader, out1, out2 io.Writer) *interpreter {
+	output := make(chan string)
 	return &interpreter{
+		name:   name,
 		in:     in,
 		out1:   out1,
 		out2:   out2,
-		tokens: make(chan token),
-		values: make(chan interface{}),
+		values: make(chan string),
 		errors: make(chan error),
+		output: output,
+		prompt: make(chan string),
+		done:   make(chan bool),
+
+		session: newSession(outputWriter(output)),
 	}
 }
 
 func (i interpreter) run(env *environment) {
-	go lex(bufio.NewReader(i.in), i.tokens)
 	go i.send()
+	i.read(bufio.NewReader(i.in), 1, env)
+	i.done <- true
+}
+
+// reads and evaluates every form in the input in, until the end of the input.
+// The input starts on the given line of the session.
+func (i interpreter) read(in io.Reader, line int, env *environment) {
+	r := reader.New(in)
+	r.Filename = i.name
+	r.SetLine(line)
+	r.Macros = i.session.macros
 	for {
-		v, err := parse(i.tokens)
+		v, err := r.Next()
 		switch err {
 		case io.EOF:
 			return
 		case nil:
-			i.eval(v, env)
+			i.eval(v, r.Pos(), env)
 		default:
 			i.errors <- err
 		}
 	}
 }
 
-func (i interpreter) eval(v interface{}, env *environment) {
-	val, err := eval(v, env)
+// evaluates the top-level value v, which was read from the position pos.
+func (i interpreter) eval(v interface{}, pos position, env *environment) {
+	val, err := eval(v, env, i.session)
 	if err != nil {
-		i.errors <- err
+		i.errors <- errorAt(pos, err)
+		return
+	}
+	if val == unspecified {
 		return
 	}
-	i.values <- val
+	// the value is printed right away, since the next form might mutate it
+	// before send gets around to writing it out.
+	i.values <- repr(val)
 }
 
+// writes values and errors out as they're produced.  Since the channels are
+// unbuffered, everything sent before done has been received by the time send
+// sees done, so nothing gets dropped at the end of the input.
 func (i interpreter) send() {
 	for {
 		select {
+		case <-i.done:
+			return
 		case v := <-i.values:
 			if i.out1 == nil {
 				return
@@ -86,6 +134,20 @@ func (i interpreter) send() {
 			if _, err := fmt.Fprintln(i.out2, e); err != nil {
 				fmt.Println("can't write error to client: ", err)
 			}
+		case s := <-i.output:
+			if i.out1 == nil {
+				return
+			}
+			if _, err := io.WriteString(i.out1, s); err != nil {
+				fmt.Println("can't write output to client: ", err)
+			}
+		case p := <-i.prompt:
+			if i.out1 == nil {
+				return
+			}
+			if _, err := io.WriteString(i.out1, p); err != nil {
+				fmt.Println("can't write prompt to client: ", err)
+			}
 		}
 	}
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"github.com/jordanorelli/skeam/reader"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// reads and evaluates each form in src, in a fresh environment inside of the
+// universe and a fresh session, and returns the printed value of the last one.
+func evalString(t *testing.T, src string) string {
+	env := newEnvironment(universe)
+	s := newSession(ioutil.Discard)
+	r := reader.New(strings.NewReader(src))
+	r.Macros = s.macros
+	var last interface{}
+	for {
+		v, err := r.Next()
+		if err == io.EOF {
+			return repr(last)
+		}
+		if err != nil {
+			t.Fatalf("unable to read %q: %v", src, err)
+		}
+		last, err = eval(v, env, s)
+		if err != nil {
+			t.Fatalf("unable to evaluate %v: %v", repr(v), err)
+		}
+	}
+}
+
+type evalTest struct {
+	name string
+	src  string
+	want string
+}
+
+func runEvalTests(t *testing.T, tests []evalTest) {
+	for _, test := range tests {
+		if got := evalString(t, test.src); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// reads and evaluates each form in src like evalString does, and returns the
+// message of the first error, which there has to be.
+func evalError(t *testing.T, src string) string {
+	env := newEnvironment(universe)
+	s := newSession(ioutil.Discard)
+	r := reader.New(strings.NewReader(src))
+	r.Macros = s.macros
+	for {
+		v, err := r.Next()
+		if err == io.EOF {
+			t.Fatalf("evaluating %q didn't fail", src)
+		}
+		if err == nil {
+			_, err = eval(v, env, s)
+		}
+		if err != nil {
+			return err.Error()
+		}
+	}
+}
+
+// runs tests whose want is part of the message of the error that their src
+// fails with.
+func runErrorTests(t *testing.T, tests []evalTest) {
+	for _, test := range tests {
+		if got := evalError(t, test.src); !strings.Contains(got, test.want) {
+			t.Errorf("%s: got error %q, want one containing %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"mutual recursion", `
+			(define is-even? (lambda (n) (if (= n 0) #t (is-odd? (- n 1)))))
+			(define is-odd? (lambda (n) (if (= n 0) #f (is-even? (- n 1)))))
+			(list (is-even? 10) (is-odd? 10) (is-even? 7) (is-odd? 7))`,
+			"(#t #f #f #t)"},
+		{"recursion keeps its arguments", `
+			(define fib (lambda (n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2))))))
+			(fib 15)`,
+			"610"},
+		{"nested closures", `
+			(define adder (lambda (x) (lambda (y) (+ x y))))
+			(define add1 (adder 1))
+			(define add5 (adder 5))
+			(list (add1 10) (add5 10) (add1 20))`,
+			"(11 15 21)"},
+		{"closures over closures", `
+			(define curry3 (lambda (a) (lambda (b) (lambda (c) (list a b c)))))
+			(define f (curry3 1))
+			(define g (f 2))
+			(list (g 3) ((f 4) 5) (((curry3 6) 7) 8))`,
+			"((1 2 3) (1 4 5) (6 7 8))"},
+		{"make-account", `
+			(define make-account
+			  (lambda (balance)
+			    (lambda (amt)
+			      (begin (set! balance (+ balance amt)) balance))))
+			(define a1 (make-account 100))
+			(a1 -20)
+			(a1 -20)`,
+			"60"},
+		{"two accounts", `
+			(define make-account
+			  (lambda (balance)
+			    (lambda (amt)
+			      (begin (set! balance (+ balance amt)) balance))))
+			(define a1 (make-account 100))
+			(define a2 (make-account 50))
+			(a1 -20)
+			(a2 10)
+			(a1 -20)
+			(list (a1 0) (a2 0))`,
+			"(60 60)"},
+		{"set! updates the defining frame", `
+			(define x 1)
+			(define bump (lambda () (set! x (+ x 1))))
+			(bump)
+			(bump)
+			x`,
+			"3"},
+	})
+}
+
+func TestQuasiquote(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"lists", "(let ((x 2) (y '(3 4))) `(1 ,x ,@y 5))", "(1 2 3 4 5)"},
+		{"dotted unquote", "(let ((x '(2 3))) `(1 . ,x))", "(1 2 3)"},
+		{"vectors", "(let ((x 2) (y '(3 4))) `#(1 ,x ,@y 5))", "#(1 2 3 4 5)"},
+		{"vectors inside of lists", "(let ((x 5)) `(a #(b ,x (c ,x)) #(#(,x))))", "(a #(b 5 (c 5)) #(#(5)))"},
+		{"an empty vector", "`#()", "#()"},
+		{"a vector that looks like an unquote", "`#(unquote x)", "#(unquote x)"},
+		{"nested quasiquotes", "`(1 `#(,(+ 1 ,(+ 1 1))))", "(1 (quasiquote #((unquote (+ 1 2)))))"},
+	})
+}
+
+func TestArguments(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"rest arguments", `
+			(define (f a b . rest) (list a b rest))
+			(list (f 1 2) (f 1 2 3 4))`,
+			"((1 2 ()) (1 2 (3 4)))"},
+		{"only rest arguments", `
+			(define (f . all) all)
+			(list (f) (f 1 2))`,
+			"(() (1 2))"},
+		{"optional arguments", `
+			(define (g a #!optional b c) (list a b c))
+			(list (g 1) (g 1 2) (g 1 2 3))`,
+			"((1 #f #f) (1 2 #f) (1 2 3))"},
+		{"case-lambda", `
+			(define h (case-lambda ((a) (list 'one a)) ((a b) (list 'two a b)) ((a b . r) (list 'more a b r))))
+			(list (h 1) (h 1 2) (h 1 2 3))`,
+			"((one 1) (two 1 2) (more 1 2 (3)))"},
+		{"case-lambda picks the first clause that fits", `
+			(define h (case-lambda ((a . r) 'rest) ((a) 'one)))
+			(h 1)`,
+			"rest"},
+	})
+}
+
+func TestArgumentErrors(t *testing.T) {
+	runErrorTests(t, []evalTest{
+		{"too few arguments", `((lambda (a b) a) 1)`,
+			"received 1 arguments in *lambda*, expected 2"},
+		{"too few with rest arguments", `(define (f a b . rest) a) (f 1)`,
+			"received 1 arguments in *f*, expected 2 (or more)"},
+		{"optional arguments", `(define (g a #!optional b c) a) (g)`,
+			"received 0 arguments in *g*, expected 1 to 3"},
+		{"too many optional arguments", `(define (g a #!optional b c) a) (g 1 2 3 4)`,
+			"received 4 arguments in *g*, expected 1 to 3"},
+		{"case-lambda", `(define h (case-lambda ((a) 1) ((a b c) 3) ((a b c d . e) 4))) (h 1 2)`,
+			"received 2 arguments in *h*, expected 1, 3 or 4 (or more)"},
+		{"builtins", `(car)`,
+			"received 0 arguments in *car*, expected 1"},
+	})
+}
+
+func TestDefine(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"bodies with more than one form", `
+			(define (f x) (define y 1) (set! y (+ y x)) y)
+			(list ((lambda () 1 2 3)) (f 5))`,
+			"(3 6)"},
+		{"curried define", `
+			(define ((adder a) b) (+ a b))
+			(define (((c3 a) b) c) (list a b c))
+			(list ((adder 1) 2) (((c3 1) 2) 3))`,
+			"(3 (1 2 3))"},
+		{"internal defines", `
+			(define (f x)
+			  (define y (* x 2))
+			  (define (g) (+ y 1))
+			  (g))
+			(f 5)`,
+			"11"},
+		{"mutually recursive internal defines", `
+			(define (outer n)
+			  (define (ev? n) (if (= n 0) #t (od? (- n 1))))
+			  (define (od? n) (if (= n 0) #f (ev? (- n 1))))
+			  (list (ev? n) (od? n)))
+			(outer 7)`,
+			"(#f #t)"},
+	})
+}
+
+func TestLet(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"let evaluates its values outside", `
+			(define x 1)
+			(let ((x 2) (y x)) (list x y))`,
+			"(2 1)"},
+		{"let* evaluates its values in order", `
+			(let* ((x 1) (y (+ x 1)) (x (* y 10))) (list x y))`,
+			"(20 2)"},
+		{"letrec", `
+			(letrec ((ev? (lambda (n) (if (= n 0) #t (od? (- n 1)))))
+			         (od? (lambda (n) (if (= n 0) #f (ev? (- n 1))))))
+			  (ev? 10))`,
+			"#t"},
+		{"letrec*", `
+			(letrec* ((a 1) (b (+ a 1)) (f (lambda () (list a b)))) (f))`,
+			"(1 2)"},
+		{"named let", `
+			(let loop ((i 0) (acc '())) (if (= i 3) acc (loop (+ i 1) (cons i acc))))`,
+			"(2 1 0)"},
+	})
+}
+
+// errors in the bindings of let and friends point at the binding that's
+// wrong, rather than at the whole form.
+func TestBindingErrors(t *testing.T) {
+	runErrorTests(t, []evalTest{
+		{"let", `(let ((a 1) (b)) a)`, ":1:13: bad binding (b) in *let*, expected (name value)"},
+		{"let with too much", `(let ((a 1) (b 2 3)) a)`, ":1:13: bad binding (b 2 3) in *let*"},
+		{"let with a bad name", `(let ((a 1) (2 3)) a)`, ":1:13: bad binding (2 3) in *let*, name must be symbol"},
+		{"let*", `(let* ((a 1) (b)) a)`, ":1:14: bad binding (b) in *let**"},
+		{"letrec", `(letrec ((a 1) b) a)`, ":1:9: bad binding b in *letrec*"},
+		{"named let", `(let loop ((i 0) (j)) i)`, ":1:18: bad binding (j) in *let*"},
+		{"on a later line", "(let ((a 1)\n      (b))\n  a)", ":2:7: bad binding (b) in *let*"},
+	})
+}
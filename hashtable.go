@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"reflect"
+)
+
+// checks the type of the hash table argument to the builtin named name.
+func hashTableArg(name string, v interface{}) (*hashTable, error) {
+	t, ok := v.(*hashTable)
+	if !ok {
+		return nil, fmt.Errorf("*%s* expects a hash table, received %v", name, reflect.TypeOf(v))
+	}
+	return t, nil
+}
+
+// checks the type of the procedure argument to the builtin named name.
+func procedureArg(name string, v interface{}) (procedure, error) {
+	proc, ok := v.(procedure)
+	if !ok {
+		return nil, fmt.Errorf("*%s* expects a procedure, received %v", name, reflect.TypeOf(v))
+	}
+	return proc, nil
+}
+
+// looks up key in the table and hands its value to then, calling the thunk
+// fail for the value if the key isn't there.  If there is no thunk, a missing
+// key is an error.
+func hashTableRef(name string, t *hashTable, key interface{}, fail []interface{}, then frame) (interface{}, error) {
+	if v, ok := t.Get(key); ok {
+		return then(v)
+	}
+	if len(fail) == 0 {
+		return nil, fmt.Errorf("*%s*: key %v not found", name, repr(key))
+	}
+	thunk, err := procedureArg(name, fail[0])
+	if err != nil {
+		return nil, err
+	}
+	return applyThen{thunk, nil, then}, nil
+}
+
+var isEqual = &builtin{
+	name:  "equal?",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		return reader.Equal(vals[0], vals[1]), nil
+	},
+}
+
+var makeHashTable = &builtin{
+	name: "make-hash-table",
+	fn: func(vals []interface{}) (interface{}, error) {
+		return reader.NewHashTable(), nil
+	},
+}
+
+var isHashTable = &builtin{
+	name:  "hash-table?",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		_, ok := vals[0].(*hashTable)
+		return ok, nil
+	},
+}
+
+var hashTableSize = &builtin{
+	name:  "hash-table-size",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		t, err := hashTableArg("hash-table-size", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		return int64(t.Len()), nil
+	},
+}
+
+var hashTableGet = &builtin{
+	name:     "hash-table-ref",
+	arity:    2,
+	variadic: true,
+	fn: func(vals []interface{}) (interface{}, error) {
+		if len(vals) > 3 {
+			return nil, arityError{expected: 3, received: len(vals), name: "hash-table-ref"}
+		}
+		t, err := hashTableArg("hash-table-ref", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		return hashTableRef("hash-table-ref", t, vals[1], vals[2:], func(v interface{}) (interface{}, error) {
+			return v, nil
+		})
+	},
+}
+
+var hashTableGetDefault = &builtin{
+	name:  "hash-table-ref/default",
+	arity: 3,
+	fn: func(vals []interface{}) (interface{}, error) {
+		t, err := hashTableArg("hash-table-ref/default", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := t.Get(vals[1]); ok {
+			return v, nil
+		}
+		return vals[2], nil
+	},
+}
+
+var hashTableSet = &builtin{
+	name:  "hash-table-set!",
+	arity: 3,
+	fn: func(vals []interface{}) (interface{}, error) {
+		t, err := hashTableArg("hash-table-set!", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		t.Set(vals[1], vals[2])
+		return unspecified, nil
+	},
+}
+
+var hashTableDelete = &builtin{
+	name:  "hash-table-delete!",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		t, err := hashTableArg("hash-table-delete!", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		t.Delete(vals[1])
+		return unspecified, nil
+	},
+}
+
+var hashTableContains = &builtin{
+	name:  "hash-table-contains?",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		t, err := hashTableArg("hash-table-contains?", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		_, ok := t.Get(vals[1])
+		return ok, nil
+	},
+}
+
+// creates a builtin that collects something from each entry of a hash table
+// into a list, in the order that the entries were added.
+func hashTableCollector(name string, fn func(key, value interface{}) interface{}) *builtin {
+	return &builtin{
+		name:  name,
+		arity: 1,
+		fn: func(vals []interface{}) (interface{}, error) {
+			t, err := hashTableArg(name, vals[0])
+			if err != nil {
+				return nil, err
+			}
+			items := make([]interface{}, 0, t.Len())
+			t.Each(func(key, value interface{}) {
+				items = append(items, fn(key, value))
+			})
+			return reader.ListFrom(items, null), nil
+		},
+	}
+}
+
+var (
+	hashTableKeys   = hashTableCollector("hash-table-keys", func(k, v interface{}) interface{} { return k })
+	hashTableValues = hashTableCollector("hash-table-values", func(k, v interface{}) interface{} { return v })
+	hashTableToList = hashTableCollector("hash-table->alist", func(k, v interface{}) interface{} { return &pair{Car: k, Cdr: v} })
+)
+
+var hashTableWalk = &builtin{
+	name:  "hash-table-walk",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		t, err := hashTableArg("hash-table-walk", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		proc, err := procedureArg("hash-table-walk", vals[1])
+		if err != nil {
+			return nil, err
+		}
+		var entries [][]interface{}
+		t.Each(func(key, value interface{}) {
+			entries = append(entries, []interface{}{key, value})
+		})
+		var step func(i int) (interface{}, error)
+		step = func(i int) (interface{}, error) {
+			if i == len(entries) {
+				return unspecified, nil
+			}
+			return applyThen{proc, entries[i], func(interface{}) (interface{}, error) {
+				return step(i + 1)
+			}}, nil
+		}
+		return step(0)
+	},
+}
+
+// (hash-table-update! table key proc [thunk]) sets the value for key to the
+// result of calling proc on its current value.  If the key isn't in the table,
+// proc is called on the result of the thunk instead.
+var hashTableUpdate = &builtin{
+	name:     "hash-table-update!",
+	arity:    3,
+	variadic: true,
+	fn: func(vals []interface{}) (interface{}, error) {
+		if len(vals) > 4 {
+			return nil, arityError{expected: 4, received: len(vals), name: "hash-table-update!"}
+		}
+		t, err := hashTableArg("hash-table-update!", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		proc, err := procedureArg("hash-table-update!", vals[2])
+		if err != nil {
+			return nil, err
+		}
+		key := vals[1]
+		return hashTableRef("hash-table-update!", t, key, vals[3:], func(v interface{}) (interface{}, error) {
+			return applyThen{proc, []interface{}{v}, func(v interface{}) (interface{}, error) {
+				t.Set(key, v)
+				return unspecified, nil
+			}}, nil
+		})
+	},
+}
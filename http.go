@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jordanorelli/skeam/am"
+	"github.com/jordanorelli/skeam/reader"
 	"html/template"
+	"io/ioutil"
 	"net/http"
 	"path/filepath"
 )
@@ -88,13 +90,38 @@ func wsHandler(ws *websocket.Conn) {
 	manager.Add(ws)
 	defer manager.Remove(ws)
 
-	i := newInterpreter(ws, wsWriter{ws}, wsErrorWriter{ws})
+	name := fmt.Sprintf("<ws %v>", ws.Request().RemoteAddr)
+	i := newInterpreter(name, ws, wsWriter{ws}, wsErrorWriter{ws})
 	i.run(universe)
 }
 
+type completeResponse struct {
+	Complete bool `json:"complete"`
+}
+
+// reports whether the forms in the request body are complete, so that the web
+// client can tell whether hitting enter should send its input off or just
+// start a new line.
+func completeHandler(w http.ResponseWriter, r *http.Request) {
+	body := r.Body
+	if max := reader.DefaultLimits.MaxForm; max > 0 {
+		body = http.MaxBytesReader(w, body, int64(max))
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(completeResponse{reader.Complete(string(b))}); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
 func runHTTPServer() {
 	http.Handle("/", &templateHandler{"home.html", map[string]interface{}{"ws_path": template.JS("/ws")}})
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(assets.AbsPath("static")))))
 	http.Handle("/ws", websocket.Handler(wsHandler))
+	http.HandleFunc("/complete", completeHandler)
 	http.ListenAndServe(*httpAddr, nil)
 }
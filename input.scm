@@ -11,6 +11,11 @@
 ; string
 "jordan"
 
+; characters
+#\a
+#\space
+#\x41
+
 ; booleans.  I don't like the look of #t and #f.  They're dumb.
 #t
 #f
@@ -34,6 +39,8 @@ x
 x
 
 (quote (1 2 3))
+'(1 2 3)
+`(1 ,x ,@(list 2 3))
 
 (if #f (quote "true-value") (quote "false-value"))
 (if #t (quote "true-value") (quote "false-value"))
@@ -52,6 +59,58 @@ x
 
 (define a1 (make-account 100.00))
 (a1 -20.00)
+(a1 -20.00)
+
+; every call gets its own arguments, so these don't trip over each other
+(define is-even? (lambda (n) (if (= n 0) #t (is-odd? (- n 1)))))
+(define is-odd? (lambda (n) (if (= n 0) #f (is-even? (- n 1)))))
+(is-even? 10)
+
+(define adder (lambda (x) (lambda (y) (+ x y))))
+(define add1 (adder 1))
+(define add5 (adder 5))
+(list (add1 10) (add5 10))
+
+; calls in tail position don't use up any stack, so this loop can run for as
+; long as it likes
+(define loop (lambda (n acc) (if (= n 0) acc (loop (- n 1) (+ acc 1)))))
+(loop 1000000 0)
+
+; call/cc can jump out of the middle of a computation
+(+ 1 (call/cc (lambda (k) (+ 10 (k 1)))))
+
+; and dynamic-wind sees the jump go by
+(define trace (quote ()))
+(define note (lambda (x) (set! trace (cons x trace))))
+(call/cc (lambda (k)
+  (dynamic-wind
+    (lambda () (note (quote in)))
+    (lambda () (k (quote escaped)))
+    (lambda () (note (quote out))))))
+trace
+
+; rest and optional parameters
+(define tagged (lambda (tag . items) (cons tag items)))
+(tagged (quote nums) 1 2 3)
+(define greet (lambda (name #!optional (greeting "hello")) (list greeting name)))
+(greet "bob")
+(greet "bob" "goodbye")
+
+; case-lambda picks a clause by how many arguments there are
+(define plus (case-lambda (() 0) ((x) x) ((x y) (+ x y))))
+(plus 4 5)
+
+; procedures can be defined with a shorthand, and their bodies can define
+; helpers of their own, which can call each other
+(define (even-odd n)
+  (define (ev? n) (if (= n 0) #t (od? (- n 1))))
+  (define (od? n) (if (= n 0) #f (ev? (- n 1))))
+  (list (ev? n) (od? n)))
+(even-odd 7)
+
+; curried define
+(define ((adder n) x) (+ n x))
+((adder 3) 4)
 
 (not "dave")
 (if (not #f) (quote "true-condition") (quote "false-condition"))
@@ -60,6 +119,9 @@ x
 
 (list 1 2 3)
 (length (list 1 2 3))
+(cons 1 2)
+'(1 2 . 3)
+(cdr (list 1 2 3))
 
 (null? null)
 
@@ -68,21 +130,30 @@ x
 ; itself, actually null?  What the fuck, lisp?
 (null? (quote null))
 
+(define v #(1 2 3))
+(vector-ref v 0)
+(vector-map + v #(10 20 30))
+(vector-set! v 0 "one")
+v
+
+(define h {"one" 1 two 2})
+(hash-table-set! h '(3) 3)
+(hash-table-ref h (list 3))
+(hash-table-ref/default h "four" 0)
+h
+
 (null? (quote ()))
 (null? (list))
 
 (symbol? (quote null))
 (symbol? 1)
 
-; ahahhahahah this is so ugly.
+; let gives the counter a variable of its own.
 (define counter
-  ((lambda ()
-    (begin
-      (define count 0)
-      (lambda ()
-        (begin
-          (set! count (+ 1 count))
-          count))))))
+  (let ((count 0))
+    (lambda ()
+      (set! count (+ 1 count))
+      count)))
 
 ; hmm, some kind of looping construct would be nice.
 (counter)
@@ -96,6 +167,55 @@ x
 (counter)
 (counter)
 
+; named let is a loop.
+(let loop ((i 0) (total 0))
+  (if (= i 5) total (loop (+ i 1) (+ total i))))
+
+; let* binds one at a time, and letrec lets the bindings see each other.
+(let* ((x 2) (y (* x 3))) y)
+(letrec ((ev? (lambda (n) (if (= n 0) #t (od? (- n 1)))))
+         (od? (lambda (n) (if (= n 0) #f (ev? (- n 1))))))
+  (ev? 10))
+
+; cond and case pick a clause, and when and unless guard a body.
+(define (sign x)
+  (cond ((< x 0) (quote negative))
+        ((= x 0) (quote zero))
+        (else (quote positive))))
+(list (sign -2) (sign 0) (sign 5))
+(case (* 2 3)
+  ((2 3 5 7) (quote prime))
+  ((1 4 6 8 9) (quote composite))
+  (else (quote big)))
+(when (> 3 2) (quote yes))
+(unless (> 3 2) (quote no))
+
+; do loops run in constant space, however long they go.
+(do ((i 0 (+ i 1))) ((= i 1000000) i))
+(define squares (make-vector 5 0))
+(do ((i 0 (+ i 1)))
+    ((= i 5) squares)
+  (vector-set! squares i (* i i)))
+
+; macros rewrite code before it's evaluated.  They're hygienic, so the tmp
+; in swap! doesn't get mixed up with the program's tmp.
+(define-syntax swap!
+  (syntax-rules ()
+    ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+(define tmp 1)
+(define other 2)
+(swap! tmp other)
+(list tmp other)
+; macroexpand-1 shows the macro's own tmp with the number it was renamed to.
+(macroexpand-1 (quote (swap! tmp other)))
+
+(define-syntax while
+  (syntax-rules ()
+    ((_ test body ...) (let loop () (when test body ... (loop))))))
+(define i 0)
+(while (< i 3) (set! i (+ i 1)))
+i
+
 ; ------------------------------------------------------------------------------
 ; norving examples
 ; ------------------------------------------------------------------------------
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"io"
+)
+
+// Evaluation runs on a machine that keeps its own stack on the heap instead
+// of using the Go stack.  The stack holds frames, each of which is what's
+// left to do with the value of some expression once that value comes in.
+// Callables never call each other directly.  Instead, they return one of the
+// instructions below, telling the machine what to evaluate next and which
+// frame, if any, should get the value.  Since nothing is ever waiting on the
+// Go stack, deep recursion only uses up memory, tail calls use up nothing at
+// all, and the stack can be captured as a continuation and resumed later.
+
+// type frame is what's left to do with the value of an expression.  It
+// returns either a value or another instruction for the machine.  Frames can
+// be resumed any number of times by continuations, so they must not change
+// the variables that they close over.
+type frame func(v interface{}) (interface{}, error)
+
+// type stack is a continuation of the machine: the frames that are waiting on
+// values, innermost first.  A stack is never changed once it's built, so
+// holding on to one is all it takes to capture a continuation.
+type stack struct {
+	frame   frame
+	pos     position // position of the form that the frame belongs to
+	winders *wind    // the dynamic-wind entries that the frame runs inside of
+	next    *stack
+}
+
+// type wind is an entry of dynamic-wind.  The entries in effect at any point
+// form a list, innermost first.
+type wind struct {
+	before procedure
+	after  procedure
+	next   *wind
+}
+
+// type tailCall is an instruction to evaluate expr in env in tail position,
+// that is, handing its value to whoever was waiting on the callable that
+// returned the tailCall.
+type tailCall struct {
+	expr interface{}
+	env  *environment
+}
+
+// type evalThen is an instruction to evaluate expr in env and hand its value
+// to the frame then.
+type evalThen struct {
+	expr interface{}
+	env  *environment
+	then frame
+}
+
+// type capture is an instruction to call proc with the current continuation.
+type capture struct {
+	proc procedure
+}
+
+// type resume is an instruction to abandon the current continuation and
+// hand value to the continuation k instead.
+type resume struct {
+	k     *continuation
+	value interface{}
+}
+
+// type windCall is an instruction to call thunk inside of a dynamic-wind
+// entry, with before called on the way in and after on the way out.
+type windCall struct {
+	before procedure
+	thunk  procedure
+	after  procedure
+}
+
+// type applyThen is an instruction to call proc with args, which have already
+// been evaluated, and hand its value to the frame then.  If then is nil, the
+// call is in tail position.  This is how builtins call procedures without
+// starting a machine of their own.
+type applyThen struct {
+	proc procedure
+	args []interface{}
+	then frame
+}
+
+// type enter is an instruction to put the dynamic-wind entry w into effect,
+// once its before thunk has been called, and then carry on with next.
+type enter struct {
+	w    *wind
+	next interface{}
+}
+
+// type output is an instruction to write text to the machine's output.  Its
+// value is unspecified.
+type output struct {
+	text string
+}
+
+// type defineMacro is an instruction to make c a macro character for m, in
+// the session that the machine is running for.  Its value is unspecified.
+type defineMacro struct {
+	c rune
+	m reader.Macro
+}
+
+// type session is what a top-level machine knows about the session that it's
+// evaluating for: where the program's output goes, and the reader macros
+// that the session has defined.  Each session has its own, so that one
+// client's reader macros don't change how everybody else's input is read.
+type session struct {
+	out    io.Writer
+	macros map[rune]reader.Macro
+}
+
+func newSession(out io.Writer) *session {
+	return &session{out: out, macros: make(map[rune]reader.Macro)}
+}
+
+type machine struct {
+	stack   *stack
+	winders *wind
+	pos     position // position of the innermost form being evaluated
+	session *session // the session that the machine evaluates for, if any
+
+	// whether the machine was started by Go code that's waiting on its
+	// result, e.g. the reader calling a reader macro.  A nested machine can't
+	// resume a continuation from outside of itself, since the Go code has to
+	// be returned from first.
+	nested bool
+}
+
+// evaluates v in env on a new top-level machine, for the session s.
+func eval(v interface{}, env *environment, s *session) (interface{}, error) {
+	m := &machine{session: s}
+	return m.run(tailCall{v, env}, nil)
+}
+
+// finishes the instruction v on a nested machine.  This is how Go code calls
+// procedures.
+func exec(v interface{}, err error) (interface{}, error) {
+	m := &machine{nested: true}
+	return m.run(v, err)
+}
+
+// runs the machine, starting with the result of some callable, until its
+// stack is empty.
+func (m *machine) run(v interface{}, err error) (interface{}, error) {
+	for {
+		if err != nil {
+			e, ok := err.(escape)
+			if !ok || !m.owns(e.k) {
+				return nil, m.fail(err)
+			}
+			v, err = m.resume(e.k, e.value)
+			continue
+		}
+
+		switch t := v.(type) {
+		case tailCall:
+			v, err = m.eval(t.expr, t.env)
+		case evalThen:
+			m.push(t.then)
+			v, err = m.eval(t.expr, t.env)
+		case capture:
+			k := &continuation{m.stack, m.winders, m}
+			v, err = t.proc.invoke([]interface{}{k})
+		case resume:
+			v, err = m.resume(t.k, t.value)
+		case windCall:
+			v, err = m.wind(t)
+		case applyThen:
+			if t.then != nil {
+				m.push(t.then)
+			}
+			v, err = t.proc.invoke(t.args)
+		case enter:
+			m.winders = t.w
+			v = t.next
+		case output:
+			v, err = m.write(t.text)
+		case defineMacro:
+			v, err = m.defineMacro(t)
+		default:
+			if m.stack == nil {
+				return v, nil
+			}
+			s := m.stack
+			m.stack, m.winders, m.pos = s.next, s.winders, s.pos
+			v, err = s.frame(v)
+		}
+	}
+}
+
+// takes one step of evaluating the expression v in env.
+func (m *machine) eval(v interface{}, env *environment) (interface{}, error) {
+	if p, ok := v.(*pair); ok {
+		if p.Pos.IsValid() {
+			m.pos = p.Pos
+		}
+		return callPair(p, env)
+	}
+	return evalAtom(v, env)
+}
+
+// evaluates the expression v, which isn't a pair, in env.  Evaluating
+// anything other than a pair never takes more than one step, so it doesn't
+// need the machine.
+func evalAtom(v interface{}, env *environment) (interface{}, error) {
+	switch t := v.(type) {
+	case symbol:
+		return env.get(t)
+	case emptyList:
+		return nil, errors.New("illegal evaluation of empty list ()")
+	}
+	return v, nil
+}
+
+// stops the machine because of the error err.  If err is a continuation
+// that we can't resume, we leave our dynamic-wind entries and pass it along
+// to the Go code that started us.
+func (m *machine) fail(err error) error {
+	e, ok := err.(escape)
+	if !ok {
+		return errorAt(m.pos, err)
+	}
+	if !m.nested {
+		return errorAt(m.pos, errors.New("can't resume a continuation that was captured inside of a reader macro, once the macro has returned"))
+	}
+	// the after thunks are run to completion here, since this machine is
+	// stopping and the continuation belongs to the one that's waiting on it.
+	for m.winders != nil {
+		w := m.winders
+		m.winders = w.next
+		if _, err := w.after.apply(nil); err != nil {
+			return err
+		}
+	}
+	return e
+}
+
+// writes text to the machine's output.  Nested machines don't know where
+// the program's output goes, so they can't write anything.
+func (m *machine) write(text string) (interface{}, error) {
+	if m.session == nil {
+		return nil, errors.New("there's nowhere to write output to from inside of a reader macro")
+	}
+	if _, err := io.WriteString(m.session.out, text); err != nil {
+		return nil, err
+	}
+	return unspecified, nil
+}
+
+// defines a reader macro for the machine's session.  Nested machines don't
+// belong to a session, and the reader that's waiting on them is in the
+// middle of using the session's macros anyway.
+func (m *machine) defineMacro(d defineMacro) (interface{}, error) {
+	if m.session == nil {
+		return nil, errors.New("reader macros can't be defined from inside of a reader macro")
+	}
+	m.session.macros[d.c] = d.m
+	return unspecified, nil
+}
+
+func (m *machine) push(f frame) {
+	m.stack = &stack{f, m.pos, m.winders, m.stack}
+}
+
+// calls t.thunk inside of a new dynamic-wind entry.  The frame that calls
+// t.after is pushed before the entry goes into effect, so the entry is gone
+// again by the time the thunk's value gets there.  The thunks all run on this
+// machine, so a continuation captured inside of any of them can be resumed
+// like any other.
+func (m *machine) wind(t windCall) (interface{}, error) {
+	w := &wind{t.before, t.after, m.winders}
+	m.push(func(v interface{}) (interface{}, error) {
+		return applyThen{t.after, nil, func(interface{}) (interface{}, error) {
+			return v, nil
+		}}, nil
+	})
+	m.push(func(interface{}) (interface{}, error) {
+		return enter{w, applyThen{proc: t.thunk}}, nil
+	})
+	return t.before.invoke(nil)
+}
+
+// whether the machine can resume the continuation k.  A machine can always
+// resume its own continuations.  Top-level machines can also resume each
+// other's, which is what lets a continuation that was captured by one form
+// be resumed by a later one.
+func (m *machine) owns(k *continuation) bool {
+	return k.owner == m || !m.nested && !k.owner.nested
+}
+
+// abandons the machine's current continuation and hands v to k instead.
+// On the way, the after thunks of the dynamic-wind entries that we're leaving
+// are called, from the inside out, and then the before thunks of the entries
+// that we're entering, from the outside in.  Each thunk is called on the
+// machine, with a frame that carries on resuming k once it returns.
+func (m *machine) resume(k *continuation, v interface{}) (interface{}, error) {
+	if !m.owns(k) {
+		return nil, escape{k, v}
+	}
+	again := func(interface{}) (interface{}, error) {
+		return resume{k, v}, nil
+	}
+	if common := commonWind(m.winders, k.winders); m.winders != common {
+		w := m.winders
+		m.winders = w.next
+		return applyThen{w.after, nil, again}, nil
+	}
+	if m.winders != k.winders {
+		// the outermost of k's entries that isn't in effect yet.
+		w := k.winders
+		for w.next != m.winders {
+			w = w.next
+		}
+		return applyThen{w.before, nil, func(interface{}) (interface{}, error) {
+			return enter{w, resume{k, v}}, nil
+		}}, nil
+	}
+	m.stack = k.stack
+	return v, nil
+}
+
+// finds the innermost entry that the lists of dynamic-wind entries a and b
+// have in common.
+func commonWind(a, b *wind) *wind {
+	depth := func(w *wind) int {
+		n := 0
+		for ; w != nil; w = w.next {
+			n++
+		}
+		return n
+	}
+	da, db := depth(a), depth(b)
+	for ; da > db; da-- {
+		a = a.next
+	}
+	for ; db > da; db-- {
+		b = b.next
+	}
+	for a != b {
+		a, b = a.next, b.next
+	}
+	return a
+}
+
+// type escape is the error that a nested machine returns when it's asked to
+// resume a continuation from outside of itself.  It makes its way back out
+// through the Go code that started the machine, until it reaches a machine
+// that can resume the continuation.
+type escape struct {
+	k     *continuation
+	value interface{}
+}
+
+func (e escape) Error() string {
+	return "continuation escaped from the procedure that resumed it"
+}
+
+// evaluates each of exprs in env, from left to right, and then calls fn with
+// their values.
+func evalArgs(exprs []interface{}, env *environment, fn func([]interface{}) (interface{}, error)) (interface{}, error) {
+	for _, expr := range exprs {
+		if _, ok := expr.(*pair); ok {
+			return evalRest(exprs, env, 0, nil, fn)
+		}
+	}
+
+	// none of the arguments need the machine, which is the usual case.
+	args := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		v, err := evalAtom(expr, env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return fn(args)
+}
+
+// type argList is a list of the values of arguments that have been
+// evaluated so far, most recent first.  It's never changed once it's built,
+// so a continuation that comes back into the middle of evaluating some
+// arguments finds the values from before it was captured.
+type argList struct {
+	value interface{}
+	next  *argList
+}
+
+// evaluates exprs from the ith on, given the values of the ones before it in
+// done.  Arguments that aren't pairs are evaluated right away, and only the
+// ones that are pairs go through the machine.
+func evalRest(exprs []interface{}, env *environment, i int, done *argList, fn func([]interface{}) (interface{}, error)) (interface{}, error) {
+	for ; i < len(exprs); i++ {
+		if _, ok := exprs[i].(*pair); ok {
+			next := i + 1
+			return evalThen{exprs[i], env, func(v interface{}) (interface{}, error) {
+				return evalRest(exprs, env, next, &argList{v, done}, fn)
+			}}, nil
+		}
+		v, err := evalAtom(exprs[i], env)
+		if err != nil {
+			return nil, err
+		}
+		done = &argList{v, done}
+	}
+
+	return fn(done.items(len(exprs)))
+}
+
+// the first n values in the list, in the order that they were added.
+func (l *argList) items(n int) []interface{} {
+	items := make([]interface{}, n)
+	for i := n - 1; i >= 0; i-- {
+		items[i], l = l.value, l.next
+	}
+	return items
+}
+
+// evaluates each of body in env, in tail position for the last one.
+func evalBody(body []interface{}, env *environment) (interface{}, error) {
+	if len(body) == 1 {
+		return tailCall{body[0], env}, nil
+	}
+	return evalThen{body[0], env, func(interface{}) (interface{}, error) {
+		return evalBody(body[1:], env)
+	}}, nil
+}
+
+// type continuation is a procedure that, when called, abandons whatever is
+// going on and carries on from where call/cc was called instead, with its
+// argument as the value of the call/cc.
+type continuation struct {
+	stack   *stack
+	winders *wind
+	owner   *machine
+}
+
+func (k *continuation) String() string {
+	return "#<continuation>"
+}
+
+func (k *continuation) call(env *environment, rawArgs []interface{}) (interface{}, error) {
+	return evalArgs(rawArgs, env, k.invoke)
+}
+
+func (k *continuation) invoke(args []interface{}) (interface{}, error) {
+	switch len(args) {
+	case 0:
+		return resume{k, unspecified}, nil
+	case 1:
+		return resume{k, args[0]}, nil
+	}
+	return nil, fmt.Errorf("received %d arguments in *continuation*, expected 0 or 1", len(args))
+}
+
+func (k *continuation) apply(args []interface{}) (interface{}, error) {
+	return exec(k.invoke(args))
+}
+
+// (call-with-current-continuation proc) calls proc with the continuation of
+// the call, as a procedure.  Calling that procedure with a value jumps back
+// to the call/cc, which then has that value, no matter what's happened since.
+var callCC = &builtin{
+	name:  "call-with-current-continuation",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		proc, err := procedureArg("call-with-current-continuation", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		return capture{proc}, nil
+	},
+}
+
+// (dynamic-wind before thunk after) calls thunk, calling before whenever
+// control enters the thunk and after whenever it leaves, whether that's by
+// returning normally or by resuming a continuation.
+var dynamicWind = &builtin{
+	name:  "dynamic-wind",
+	arity: 3,
+	fn: func(vals []interface{}) (interface{}, error) {
+		var procs [3]procedure
+		for i, v := range vals {
+			proc, err := procedureArg("dynamic-wind", v)
+			if err != nil {
+				return nil, err
+			}
+			procs[i] = proc
+		}
+		return windCall{procs[0], procs[1], procs[2]}, nil
+	},
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+// loops that make their recursive call in tail position, through each of the
+// forms that pass tail position on to one of their parts.  They're run with a
+// small Go stack, so anything that makes evaluation recurse on the Go stack
+// crashes the test instead of quietly growing the stack.
+func TestTailCalls(t *testing.T) {
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	runEvalTests(t, []evalTest{
+		{"a million iterations", `
+			(define loop (lambda (n acc) (if (= n 0) acc (loop (- n 1) (+ acc 1)))))
+			(loop 1000000 0)`,
+			"1000000"},
+		{"cond", `
+			(define loop (lambda (n)
+			  (cond ((= n 0) 'done)
+			        ((< n 0) (loop (+ n 1)))
+			        (else (loop (- n 1))))))
+			(loop 100000)`,
+			"done"},
+		{"cond =>", `
+			(define loop (lambda (n)
+			  (cond ((= n 0) 'done)
+			        ((- n 1) => loop))))
+			(loop 100000)`,
+			"done"},
+		{"and", `
+			(define loop (lambda (n) (and #t (if (= n 0) 'done (loop (- n 1))))))
+			(loop 100000)`,
+			"done"},
+		{"or", `
+			(define loop (lambda (n) (or (= n 0) (loop (- n 1)))))
+			(loop 100000)`,
+			"#t"},
+		{"when", `
+			(define loop (lambda (n acc) (when #t (if (= n 0) acc (loop (- n 1) (+ acc 2))))))
+			(loop 100000 0)`,
+			"200000"},
+		{"unless", `
+			(define loop (lambda (n) (unless (= n 0) (loop (- n 1)))))
+			(loop 100000)
+			'done`,
+			"done"},
+		{"let", `
+			(define loop (lambda (n) (let ((m (- n 1))) (if (< m 0) 'done (loop m)))))
+			(loop 100000)`,
+			"done"},
+		{"named let", `
+			(let loop ((n 100000) (acc 0)) (if (= n 0) acc (loop (- n 1) (+ acc 1))))`,
+			"100000"},
+		{"begin", `
+			(define loop (lambda (n) (begin 1 (if (= n 0) 'done (loop (- n 1))))))
+			(loop 100000)`,
+			"done"},
+		{"mutual recursion", `
+			(define is-even? (lambda (n) (if (= n 0) #t (is-odd? (- n 1)))))
+			(define is-odd? (lambda (n) (if (= n 0) #f (is-even? (- n 1)))))
+			(is-even? 100001)`,
+			"#f"},
+	})
+}
+
+// builtins that call procedures do it on the machine, so recursion through
+// them doesn't use up the Go stack, and continuations captured inside of
+// them can be resumed after they've returned.
+func TestBuiltinCalls(t *testing.T) {
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	runEvalTests(t, []evalTest{
+		{"recursion through vector-map", `
+			(define (f n) (if (= n 0) 0 (+ 1 (vector-ref (vector-map f (vector (- n 1))) 0))))
+			(f 100000)`,
+			"100000"},
+		{"recursion through vector-for-each", `
+			(define total 0)
+			(define (f n) (if (> n 0) (vector-for-each (lambda (x) (set! total (+ total x)) (f (- n 1))) (vector n))))
+			(f 100000)
+			total`,
+			"5000050000"},
+		{"recursion through hash-table-update!", `
+			(define t (make-hash-table))
+			(define (f n) (if (> n 0) (hash-table-update! t 'n (lambda (v) (f (- n 1)) (+ n 1)) (lambda () 0))))
+			(f 100000)
+			(hash-table-ref t 'n)`,
+			"100001"},
+		{"recursion through dynamic-wind", `
+			(define (f n) (if (= n 0) 0 (dynamic-wind (lambda () #f) (lambda () (+ 1 (f (- n 1)))) (lambda () #f))))
+			(f 100000)`,
+			"100000"},
+		{"hash-table-walk", `
+			(define t (make-hash-table))
+			(hash-table-set! t 'a 1)
+			(hash-table-set! t 'b 2)
+			(define seen '())
+			(hash-table-walk t (lambda (k v) (set! seen (cons (list k v) seen))))
+			seen`,
+			"((b 2) (a 1))"},
+		{"hash-table-ref thunk", `
+			(hash-table-ref (make-hash-table) 'missing (lambda () 'default))`,
+			"default"},
+		{"resuming vector-map", `
+			(define saved #f)
+			(define count 0)
+			(define v (vector-map (lambda (x) (call/cc (lambda (k) (if (= x 2) (set! saved k)) x))) #(1 2 3)))
+			(set! count (+ count 1))
+			(if (= count 1) (saved 20))
+			v`,
+			"#(1 20 3)"},
+		{"escaping dynamic-wind", `
+			(define trace '())
+			(define (note x) (set! trace (cons x trace)))
+			(call/cc (lambda (k)
+			  (dynamic-wind
+			    (lambda () (note 'in))
+			    (lambda () (k 'out))
+			    (lambda () (note 'after)))))
+			trace`,
+			"(after in)"},
+		{"reentering dynamic-wind", `
+			(define trace '())
+			(define (note x) (set! trace (cons x trace)))
+			(define re #f)
+			(define n 0)
+			(dynamic-wind
+			  (lambda () (note 'before))
+			  (lambda () (call/cc (lambda (k) (set! re k))))
+			  (lambda () (note 'after)))
+			(set! n (+ n 1))
+			(if (< n 3) (re n))
+			trace`,
+			"(after before after before)"},
+	})
+}
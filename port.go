@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"io"
+	"reflect"
+)
+
+// type eofObject is the type of the value that ports return at the end of
+// their input.  It has no other values.
+type eofObject struct{}
+
+func (eofObject) String() string {
+	return "#<eof>"
+}
+
+var eof = eofObject{}
+
+// checks the type of the port argument to the builtin named name.
+func portArg(name string, v interface{}) (*port, error) {
+	p, ok := v.(*port)
+	if !ok {
+		return nil, fmt.Errorf("*%s* expects a port, received %v", name, reflect.TypeOf(v))
+	}
+	return p, nil
+}
+
+// (define-reader-macro #\@ (lambda (port) ...)) makes @ a macro character.
+// When the reader runs into an @ at the start of a datum, it calls the
+// procedure with a port that reads the input following the @, and whatever
+// the procedure returns is what was read.
+var defineReaderMacro = &builtin{
+	name:  "define-reader-macro",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		c, ok := vals[0].(char)
+		if !ok {
+			return nil, fmt.Errorf("*define-reader-macro* expects a char, received %v", reflect.TypeOf(vals[0]))
+		}
+		if err := reader.CheckMacroChar(c); err != nil {
+			return nil, err
+		}
+		proc, err := procedureArg("define-reader-macro", vals[1])
+		if err != nil {
+			return nil, err
+		}
+		return defineMacro{rune(c), func(c char, p *port) (interface{}, error) {
+			return proc.apply([]interface{}{p})
+		}}, nil
+	},
+}
+
+// creates a builtin that reads something from a port, returning the eof
+// object at the end of the input.
+func portReader(name string, fn func(*port) (interface{}, error)) *builtin {
+	return &builtin{
+		name:  name,
+		arity: 1,
+		fn: func(vals []interface{}) (interface{}, error) {
+			p, err := portArg(name, vals[0])
+			if err != nil {
+				return nil, err
+			}
+			v, err := fn(p)
+			if err == io.EOF {
+				return eof, nil
+			}
+			return v, err
+		},
+	}
+}
+
+var (
+	readChar = portReader("read-char", func(p *port) (interface{}, error) { return p.ReadChar() })
+	peekChar = portReader("peek-char", func(p *port) (interface{}, error) { return p.PeekChar() })
+	read     = portReader("read", func(p *port) (interface{}, error) { return p.Read() })
+)
+
+var isEOFObject = &builtin{
+	name:  "eof-object?",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		return vals[0] == eof, nil
+	},
+}
+
+// (write obj) writes obj out the way that the repl prints values, so that it
+// can be read back in.  (display obj) writes it out for people to read, with
+// strings and characters as their bare contents.  The output goes wherever
+// the values of the session go.
+var (
+	write = &builtin{
+		name:  "write",
+		arity: 1,
+		fn: func(vals []interface{}) (interface{}, error) {
+			return output{reader.Write(vals[0])}, nil
+		},
+	}
+	display = &builtin{
+		name:  "display",
+		arity: 1,
+		fn: func(vals []interface{}) (interface{}, error) {
+			return output{reader.Display(vals[0])}, nil
+		},
+	}
+	newline = &builtin{
+		name: "newline",
+		fn: func(vals []interface{}) (interface{}, error) {
+			return output{"\n"}, nil
+		},
+	}
+)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReaderMacros(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"read-char and peek-char", `
+			(define-reader-macro #\@ (lambda (p) (let ((c (peek-char p))) (list c (read-char p) (read-char p)))))
+			'@xy`,
+			`(#\x #\x #\y)`},
+		{"read", `
+			(define-reader-macro #\@ (lambda (p) (list 'quote (read p))))
+			(list @(a b) @c)`,
+			"((a b) c)"},
+		{"eof", `
+			(define-reader-macro #\@ (lambda (p) (list (eof-object? (peek-char p)) (eof-object? (read-char p)) (eof-object? (read p)))))
+			'@`,
+			"(#t #t #t)"},
+		{"macro characters inside of symbols", `
+			(define-reader-macro #\@ (lambda (p) 1))
+			(define a@ 2)
+			(list @ a@)`,
+			"(1 2)"},
+	})
+}
+
+func TestReaderMacroErrors(t *testing.T) {
+	runErrorTests(t, []evalTest{
+		{"reserved character", `(define-reader-macro #\( (lambda (p) 1))`, `#\( can't be a macro character`},
+		{"symbol character", `(define-reader-macro #\+ (lambda (p) 1))`, "since it can start a symbol or a number"},
+		{"not a char", `(define-reader-macro "@" (lambda (p) 1))`, "expects a char"},
+		{"not a procedure", `(define-reader-macro #\@ 1)`, "expects a procedure"},
+		{"not a port", `(read-char 1)`, "*read-char* expects a port"},
+		{"closed port", `
+			(define saved #f)
+			(define-reader-macro #\@ (lambda (p) (set! saved p) 1))
+			@
+			(read-char saved)`,
+			"port is closed"},
+		{"defining inside of a macro", `
+			(define-reader-macro #\@ (lambda (p) (define-reader-macro #\| (lambda (p) 1))))
+			@`,
+			"reader macros can't be defined from inside of a reader macro"},
+	})
+}
+
+// reader macros belong to the session that defined them, so one client can't
+// change how another one's input is read.
+func TestReaderMacroSessions(t *testing.T) {
+	if _, errs := runRepl("(define-reader-macro #\\@ (lambda (p) (car '())))\n"); len(errs) != 1 || errs[0] != "" {
+		t.Fatalf("defining the macro failed with errors %q", errs)
+	}
+	out, errs := runRepl("(define @x 1)\n@x\n")
+	if !strings.Contains(out, "1\n") || len(errs) != 1 || errs[0] != "" {
+		t.Errorf("another session read @x as %q, with errors %q", out, errs)
+	}
+}
@@ -0,0 +1,153 @@
+package reader
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// type Pos describes a location in some lispy source input.  Lines and
+// columns both start at 1.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// whether or not the position actually points somewhere.  Values that were
+// constructed at runtime instead of read from source have no position.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// type Error is a syntax error, such as a malformed number, an unknown string
+// escape or an unbalanced paren.
+type Error struct {
+	Pos Pos
+	Msg string
+
+	// Incomplete is set when the input ended in the middle of a datum, e.g.
+	// partway through a list or a string.  Errors like that go away if more
+	// input is added, which is how a REPL knows to wait for more lines.
+	Incomplete bool
+
+	// whether the error came from the lexer, which has already skipped ahead
+	// to the next top-level form by the time the reader sees the error.
+	lexed bool
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%v: %s", e.Pos, e.Msg)
+}
+
+// annotates an error with the position p, unless it already is an Error.
+func errorAt(p Pos, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(Error); ok {
+		return err
+	}
+	return Error{Pos: p, Msg: err.Error()}
+}
+
+// type Symbol is an identifier, like foo or set-car!.
+type Symbol string
+
+// type Pair is a cons cell, the building block of lists.  A list is either
+// the empty list, Null, or a pair whose Cdr is a list.  A pair whose Cdr isn't
+// a list is written as a dotted pair, e.g. (1 . 2).  Since lists are built out
+// of pairs, lists share structure: the Cdr of a list is the very same list
+// that it was consed onto.
+type Pair struct {
+	Car interface{}
+	Cdr interface{}
+	Pos Pos // where the pair was read from, if anywhere
+}
+
+func (p *Pair) String() string {
+	return Write(p)
+}
+
+// type EmptyList is the type of the empty list, Null.  It has no other values.
+type EmptyList struct{}
+
+func (EmptyList) String() string {
+	return "()"
+}
+
+// the empty list, ()
+var Null = EmptyList{}
+
+// builds a list out of a slice of items.  The last pair of the list has tail
+// as its Cdr, so a tail of Null makes a proper list.
+func ListFrom(items []interface{}, tail interface{}) interface{} {
+	v := tail
+	for i := len(items) - 1; i >= 0; i-- {
+		v = &Pair{Car: items[i], Cdr: v}
+	}
+	return v
+}
+
+// type Char is a single unicode character, written as #\a
+type Char rune
+
+// named characters, as they appear after the #\ in a character literal.
+var charNames = map[string]Char{
+	"alarm":     '\a',
+	"backspace": '\b',
+	"delete":    0x7f,
+	"escape":    0x1b,
+	"newline":   '\n',
+	"null":      0,
+	"return":    '\r',
+	"space":     ' ',
+	"tab":       '\t',
+}
+
+func (c Char) String() string {
+	for name, named := range charNames {
+		if c == named {
+			return `#\` + name
+		}
+	}
+	if !unicode.IsPrint(rune(c)) {
+		return fmt.Sprintf(`#\x%x`, rune(c))
+	}
+	return `#\` + string(rune(c))
+}
+
+// parses the lexeme of a character literal, e.g. #\a, #\space or #\x41, into
+// a Char.
+func parseChar(lexeme string) (Char, error) {
+	name := lexeme[2:]
+	if utf8.RuneCountInString(name) == 1 {
+		r, _ := utf8.DecodeRuneInString(name)
+		return Char(r), nil
+	}
+	if c, ok := charNames[name]; ok {
+		return c, nil
+	}
+	if name[0] == 'x' {
+		n, err := strconv.ParseUint(name[1:], 16, 32)
+		if err == nil && utf8.ValidRune(rune(n)) {
+			return Char(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown character name %s", lexeme)
+}
+
+// type Vector is a fixed-length sequence of values with constant-time access
+// to each element.  Vector literals are written #(1 2 3).
+type Vector struct {
+	Items []interface{}
+}
+
+func (v *Vector) String() string {
+	return Write(v)
+}
@@ -0,0 +1,261 @@
+package reader
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// type HashTable maps keys to values, comparing keys with Equal, so strings,
+// numbers, symbols and lists can all be used as keys.  Hash table literals are
+// written {key value ...}.  A key shouldn't be changed while it's in a table,
+// since the table won't be able to find it again.
+type HashTable struct {
+	buckets map[uint64][]*entry
+	size    int
+	seq     int // the number of entries that have ever been added
+}
+
+type entry struct {
+	key   interface{}
+	value interface{}
+	seq   int // when the entry was added, to keep the entries in order
+}
+
+func NewHashTable() *HashTable {
+	return &HashTable{buckets: make(map[uint64][]*entry)}
+}
+
+func (t *HashTable) String() string {
+	return Write(t)
+}
+
+// finds the entry for key, along with the hash of the key.
+func (t *HashTable) find(key interface{}) (*entry, uint64) {
+	h := hashOf(key)
+	for _, e := range t.buckets[h] {
+		if Equal(e.key, key) {
+			return e, h
+		}
+	}
+	return nil, h
+}
+
+// gets the value for key.  The second return value is false if the key isn't
+// in the table.
+func (t *HashTable) Get(key interface{}) (interface{}, bool) {
+	e, _ := t.find(key)
+	if e == nil {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// sets the value for key, adding the key to the table if it isn't already
+// there.
+func (t *HashTable) Set(key, value interface{}) {
+	e, h := t.find(key)
+	if e != nil {
+		e.value = value
+		return
+	}
+	t.buckets[h] = append(t.buckets[h], &entry{key, value, t.seq})
+	t.seq++
+	t.size++
+}
+
+// removes key from the table, if it's there.
+func (t *HashTable) Delete(key interface{}) {
+	h := hashOf(key)
+	bucket := t.buckets[h]
+	for i, e := range bucket {
+		if Equal(e.key, key) {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			if len(bucket) == 0 {
+				delete(t.buckets, h)
+			} else {
+				t.buckets[h] = bucket
+			}
+			t.size--
+			return
+		}
+	}
+}
+
+// the number of entries in the table.
+func (t *HashTable) Len() int {
+	return t.size
+}
+
+// calls fn with each of the table's entries, in the order that they were
+// added.  fn is free to change the table, since the entries are collected up
+// front.
+func (t *HashTable) Each(fn func(key, value interface{})) {
+	entries := make([]*entry, 0, t.size)
+	for _, bucket := range t.buckets {
+		entries = append(entries, bucket...)
+	}
+	sort.Sort(bySeq(entries))
+	for _, e := range entries {
+		fn(e.key, e.value)
+	}
+}
+
+type bySeq []*entry
+
+func (s bySeq) Len() int           { return len(s) }
+func (s bySeq) Less(i, j int) bool { return s[i].seq < s[j].seq }
+func (s bySeq) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// checks whether a and b are the same value, the way that Scheme's equal?
+// does.  Lists and vectors are equal if their items are equal, and strings
+// are equal if they have the same characters.  Numbers are only equal to
+// numbers of the same exactness, so 1 isn't equal to 1.0.  Hash tables and
+// other values are only equal to themselves.  Structures that contain
+// themselves are compared without going around in circles.
+func Equal(a, b interface{}) bool {
+	var c comparison
+	return c.equal(a, b)
+}
+
+// type comparison keeps track of the pairs and vectors that we've already
+// started comparing.  If we run into them again, we're in a cycle, and they
+// can be assumed to be equal, since any difference will turn up elsewhere.
+type comparison struct {
+	seen map[[2]interface{}]bool
+}
+
+// marks a and b as being compared, returning false if they already were.
+func (c *comparison) visit(a, b interface{}) bool {
+	if c.seen == nil {
+		c.seen = make(map[[2]interface{}]bool)
+	}
+	k := [2]interface{}{a, b}
+	if c.seen[k] {
+		return false
+	}
+	c.seen[k] = true
+	return true
+}
+
+func (c *comparison) equal(a, b interface{}) bool {
+	for {
+		switch x := a.(type) {
+		case *Pair:
+			y, ok := b.(*Pair)
+			if !ok {
+				return false
+			}
+			if x == y || !c.visit(x, y) {
+				return true
+			}
+			if !c.equal(x.Car, y.Car) {
+				return false
+			}
+			a, b = x.Cdr, y.Cdr
+			continue
+		case *Vector:
+			y, ok := b.(*Vector)
+			if !ok || len(x.Items) != len(y.Items) {
+				return false
+			}
+			if x == y || !c.visit(x, y) {
+				return true
+			}
+			for i := range x.Items {
+				if !c.equal(x.Items[i], y.Items[i]) {
+					return false
+				}
+			}
+			return true
+		}
+		ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+		if ta != tb {
+			return false
+		}
+		// values that Go can't compare, like structs with funcs in them,
+		// can't be told apart from copies of themselves, so they're never
+		// equal.  Anything that needs to be equal to itself, like the
+		// interpreter's procedures, should be a pointer.
+		if ta == nil || !ta.Comparable() {
+			return ta == nil
+		}
+		return a == b
+	}
+}
+
+// the number of values that hashOf will look at in a structure.  Values
+// that are equal have equal prefixes, so the hash only depends on the start
+// of a structure, which also keeps hashing a cyclic structure from looping
+// forever.
+const hashBudget = 32
+
+// hashes v in a way that's consistent with Equal: values that are Equal have
+// the same hash.
+func hashOf(v interface{}) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	budget := hashBudget
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		for budget > 0 {
+			budget--
+			switch x := v.(type) {
+			case *Pair:
+				h.Write([]byte{'p'})
+				walk(x.Car)
+				v = x.Cdr
+				continue
+			case *Vector:
+				h.Write([]byte{'v'})
+				for _, item := range x.Items {
+					walk(item)
+				}
+			case int64:
+				binary.LittleEndian.PutUint64(buf[:], uint64(x))
+				h.Write([]byte{'i'})
+				h.Write(buf[:])
+			case float64:
+				if x == 0 {
+					x = 0 // -0.0 is equal to 0.0, but has different bits
+				}
+				binary.LittleEndian.PutUint64(buf[:], math.Float64bits(x))
+				h.Write([]byte{'f'})
+				h.Write(buf[:])
+			case string:
+				h.Write([]byte{'s'})
+				h.Write([]byte(x))
+			case Symbol:
+				h.Write([]byte{'y'})
+				h.Write([]byte(x))
+			case Char:
+				binary.LittleEndian.PutUint32(buf[:], uint32(x))
+				h.Write([]byte{'c'})
+				h.Write(buf[:4])
+			case bool:
+				if x {
+					h.Write([]byte{'t'})
+				} else {
+					h.Write([]byte{'F'})
+				}
+			default:
+				// everything else is only equal to itself, so it's enough to
+				// hash the type and let Equal sort out the rest.  Pointers,
+				// like the interpreter's procedures, are hashed by where they
+				// point, so that they don't all end up in the same bucket.
+				if v != nil {
+					h.Write([]byte(reflect.TypeOf(v).String()))
+					if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+						binary.LittleEndian.PutUint64(buf[:], uint64(rv.Pointer()))
+						h.Write(buf[:])
+					}
+				}
+			}
+			return
+		}
+	}
+	walk(v)
+	return h.Sum64()
+}
@@ -0,0 +1,967 @@
+package reader
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	invalidToken tokenType = iota
+	integerToken
+	symbolToken
+	openParenToken
+	closeParenToken
+	openBraceToken
+	closeBraceToken
+	vectorToken
+	stringToken
+	floatToken
+	numberToken
+	charToken
+	boolToken
+	quoteToken
+	quasiquoteToken
+	unquoteToken
+	unquoteSplicingToken
+	datumCommentToken
+	macroToken
+	labelToken
+	labelRefToken
+	dotToken
+	errorToken
+	incompleteToken
+	eofToken
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case integerToken:
+		return "integer"
+	case symbolToken:
+		return "symbol"
+	case openParenToken:
+		return "open_paren"
+	case closeParenToken:
+		return "close_paren"
+	case openBraceToken:
+		return "open_brace"
+	case closeBraceToken:
+		return "close_brace"
+	case vectorToken:
+		return "vector"
+	case stringToken:
+		return "string"
+	case floatToken:
+		return "float"
+	case numberToken:
+		return "number"
+	case charToken:
+		return "char"
+	case boolToken:
+		return "bool"
+	case quoteToken:
+		return "quote"
+	case quasiquoteToken:
+		return "quasiquote"
+	case unquoteToken:
+		return "unquote"
+	case unquoteSplicingToken:
+		return "unquote_splicing"
+	case datumCommentToken:
+		return "datum_comment"
+	case macroToken:
+		return "macro"
+	case labelToken:
+		return "label"
+	case labelRefToken:
+		return "label_ref"
+	case dotToken:
+		return "dot"
+	case errorToken:
+		return "error"
+	case incompleteToken:
+		return "incomplete"
+	case eofToken:
+		return "eof"
+	}
+	panic("wtf")
+}
+
+type token struct {
+	lexeme string
+	t      tokenType
+	pos    Pos
+}
+
+// type lexError is an error encountered by the lexer, such as a malformed
+// number or an unknown string escape.  Lex errors are queued up as error
+// tokens, which the reader turns into Errors.
+type lexError struct {
+	pos Pos
+	msg string
+
+	// whether the error is that the input ended in the middle of a token,
+	// which more input might fix.
+	incomplete bool
+}
+
+func (e lexError) Error() string {
+	return fmt.Sprintf("%v: %s", e.pos, e.msg)
+}
+
+type stateFn func(*lexer) (stateFn, error)
+
+// type lexer turns runes into tokens.  The lexer doesn't run on its own;
+// whoever wants a token calls token, which steps the state machine one rune at
+// a time until a token comes out.
+type lexer struct {
+	in     io.RuneScanner
+	buf    []byte // the lexeme in progress, utf-8 encoded
+	lexing bool   // whether there's a lexeme in progress in buf
+	cur    rune
+	file   string
+	line   int
+	col    int
+	start  Pos            // position of the first rune in buf
+	state  stateFn        // the state that handles the next rune
+	macros map[rune]Macro // the reader macros, keyed by macro character
+	done   bool           // whether we've reached the end of the input
+
+	limits     Limits
+	form       int  // how many bytes have been read for the current top-level datum
+	recovering bool // whether we're skipping the rest of a top-level datum after an error
+
+	// tokens that have been lexed but not yet handed out.  Most runes produce
+	// at most one token, but a delimiter can end one token and start another.
+	queue []token
+	head  int
+
+	// how many block comments deep we are.  Block comments nest, so we need
+	// to know how many closing |# to wait for.
+	comments int
+
+	depth     int  // how many parens deep we are
+	inString  bool // whether we're inside of a string literal
+	escape    rune // value of the hex escape in progress
+	escapeLen int  // number of digits in the hex escape in progress
+}
+
+func newLexer(in io.RuneScanner) *lexer {
+	return &lexer{
+		in:    in,
+		buf:   make([]byte, 0, 64),
+		cur:   ' ',
+		line:  1,
+		state: lexWhitespace,
+	}
+}
+
+// queues up a token made out of the current lexeme and clears the lexeme
+// buffer.  There's no sanity checking to make sure you don't emit some
+// bullshit, so don't fuck it up.
+func (l *lexer) emit(t tokenType) {
+	l.queue = append(l.queue, token{lexeme: string(l.buf), t: t, pos: l.start})
+	l.lexing = false
+}
+
+// emits a token consisting of only the current rune.
+func (l *lexer) emitCur(t tokenType) {
+	l.keep()
+	l.emit(t)
+}
+
+// checks whether the current rune ends the lexeme in progress.  If it does, a
+// token of type t is emitted, the delimiter is lexed, and the state that
+// comes after the delimiter is returned.  Otherwise, delimit returns nil.
+func (l *lexer) delimit(t tokenType) stateFn {
+	switch l.cur {
+	case ' ', '\t', '\n', '\r', '(', ')', '[', ']', '{', '}', ';':
+		l.emit(t)
+		f, _ := lexWhitespace(l)
+		return f
+	}
+	return nil
+}
+
+// the position of the current rune
+func (l *lexer) pos() Pos {
+	return Pos{File: l.file, Line: l.line, Col: l.col}
+}
+
+// starts a new, empty lexeme at the current rune.  The buffer is reused from
+// one lexeme to the next; emit copies the lexeme out of it.
+func (l *lexer) mark() {
+	l.buf = l.buf[:0]
+	l.lexing = true
+	l.start = l.pos()
+}
+
+// creates an error for a malformed number.  The error points at the start of
+// the number, not the rune that we choked on.
+func (l *lexer) badNumber() error {
+	return lexError{l.start, fmt.Sprintf("malformed number %q: unexpected %q", string(l.buf), l.cur), false}
+}
+
+// creates an error that points at the current rune.
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return lexError{l.pos(), fmt.Sprintf(format, args...), false}
+}
+
+// queues up a lex error for the reader and throws away whatever lexeme was in
+// progress.
+func (l *lexer) fail(e lexError) {
+	t := errorToken
+	if e.incomplete {
+		t = incompleteToken
+	}
+	l.queue = append(l.queue, token{e.msg, t, e.pos})
+	l.lexing = false
+	l.comments = 0
+}
+
+// throws away whatever has been lexed but not yet handed out and skips ahead
+// to the next top-level form.
+func (l *lexer) skip() {
+	l.queue, l.head = l.queue[:0], 0
+	l.lexing = false
+	l.comments = 0
+	l.state = lexRecover
+	l.recovering = true
+}
+
+// finishes skipping a top-level datum after an error.  The next top-level
+// datum starts here, so its length is counted from here.
+func (l *lexer) recovered() (stateFn, error) {
+	l.recovering = false
+	l.form = 0
+	return lexWhitespace, nil
+}
+
+// reads a rune from the input and assigns it to the current rune, l.cur.
+// Returns an error if we were unable to read a rune from the input.  I'm
+// pretty sure it's always io.EOF but I'm not positive.
+func (l *lexer) next() error {
+	r, _, err := l.in.ReadRune()
+	if err != nil {
+		return err
+	}
+	l.form += utf8.RuneLen(r)
+	l.advance(r)
+	return nil
+}
+
+// moves the lexer forward onto the rune r.
+func (l *lexer) advance(r rune) {
+	if l.cur == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	l.cur = r
+}
+
+// stores the current rune in our in-progress lexeme buffer
+func (l *lexer) keep() {
+	l.add(l.cur)
+}
+
+// stores the rune r in our in-progress lexeme buffer.  This is for when the
+// lexeme isn't spelled the same way as it appears in the source, e.g. escape
+// sequences in strings.
+func (l *lexer) add(r rune) {
+	if !l.lexing {
+		l.mark()
+	}
+	if r < utf8.RuneSelf {
+		l.buf = append(l.buf, byte(r))
+		return
+	}
+	var enc [utf8.UTFMax]byte
+	n := utf8.EncodeRune(enc[:], r)
+	l.buf = append(l.buf, enc[:n]...)
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+// lexes an open parenthesis
+func lexOpenParen(l *lexer) (stateFn, error) {
+	l.queue = append(l.queue, token{"(", openParenToken, l.pos()})
+	l.depth++
+	return lexWhitespace, nil
+}
+
+func lexSign(l *lexer) (stateFn, error) {
+	if f := l.delimit(symbolToken); f != nil {
+		return f, nil
+	}
+	switch l.cur {
+	case '.':
+		l.keep()
+		return lexDot, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexInt, nil
+	}
+	l.keep()
+	return lexSymbol, nil
+}
+
+// lexes the rune after a leading period.  A period followed by a digit is a
+// float like .5, a lone period is the dot in a dotted pair like (a . b), and
+// anything else is the start of a symbol like ...
+func lexDot(l *lexer) (stateFn, error) {
+	if len(l.buf) == 1 {
+		if f := l.delimit(dotToken); f != nil {
+			return f, nil
+		}
+	}
+	if f := l.delimit(symbolToken); f != nil {
+		return f, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexFloat, nil
+	}
+	l.keep()
+	return lexSymbol, nil
+}
+
+// lexes some whitespace in progress.  Maybe this should be combined with root
+// and the lexer shouldn't have a state.  I think wehat I'm doing now is
+// "wrong" but who honestly gives a shit.
+func lexWhitespace(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case ' ', '\t', '\n', '\r':
+		return lexWhitespace, nil
+	case '"':
+		l.mark()
+		l.inString = true
+		return lexString, nil
+	case '(':
+		return lexOpenParen(l)
+	case ')':
+		return lexCloseParen(l)
+	case '[':
+		return lexOpenBracket(l)
+	case ']':
+		return lexCloseBracket(l)
+	case '{':
+		return lexOpenBrace(l)
+	case '}':
+		return lexCloseBrace(l)
+	case ';':
+		return lexComment, nil
+	case '\'':
+		l.emitCur(quoteToken)
+		return lexWhitespace, nil
+	case '`':
+		l.emitCur(quasiquoteToken)
+		return lexWhitespace, nil
+	case ',':
+		l.keep()
+		return lexUnquote, nil
+	case '#':
+		l.keep()
+		return lexHash, nil
+	case '.':
+		l.keep()
+		return lexDot, nil
+	case '-', '+':
+		l.keep()
+		return lexSign, nil
+	}
+	if _, ok := l.macros[l.cur]; ok {
+		l.emitCur(macroToken)
+		return lexWhitespace, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexInt, nil
+	}
+	l.keep()
+	return lexSymbol, nil
+}
+
+// lexes the rune following a comma.  A comma followed by an at sign is an
+// unquote-splicing, anything else is a plain unquote, in which case the
+// current rune is the start of whatever is being unquoted.
+func lexUnquote(l *lexer) (stateFn, error) {
+	if l.cur == '@' {
+		l.keep()
+		l.emit(unquoteSplicingToken)
+		return lexWhitespace, nil
+	}
+	l.emit(unquoteToken)
+	return lexWhitespace(l)
+}
+
+// lexes the rune following a hash.  A hash followed by a backslash starts a
+// character literal, a hash followed by t or f starts a boolean, and a hash
+// followed by a radix or exactness prefix starts a number.  #( starts a
+// vector, #| starts a block comment, #; comments out the next datum and a
+// hash followed by a digit starts a datum label.  Anything else is treated as
+// a symbol.
+func lexHash(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '\\':
+		l.keep()
+		return lexChar, nil
+	case 't', 'f':
+		l.keep()
+		return lexBool, nil
+	case 'x', 'X', 'b', 'B', 'o', 'O', 'd', 'D', 'e', 'E', 'i', 'I':
+		l.keep()
+		return lexPrefixedNumber, nil
+	case '|':
+		l.lexing = false
+		l.comments++
+		return lexBlockComment, nil
+	case ';':
+		l.keep()
+		l.emit(datumCommentToken)
+		return lexWhitespace, nil
+	case '(':
+		l.keep()
+		l.emit(vectorToken)
+		l.depth++
+		return lexWhitespace, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexLabel, nil
+	}
+	return lexSymbol(l)
+}
+
+// lexes a datum label in progress, like #0= or #0#.  #0= labels the datum
+// that follows it, and #0# stands for the datum that was labeled.
+func lexLabel(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '=':
+		l.keep()
+		l.emit(labelToken)
+		return lexWhitespace, nil
+	case '#':
+		l.keep()
+		return lexLabelRef, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexLabel, nil
+	}
+	return nil, lexError{l.start, fmt.Sprintf("malformed datum label %q: unexpected %q", string(l.buf), l.cur), false}
+}
+
+// lexes the rune after a datum label reference like #0#, which has to be a
+// delimiter.
+func lexLabelRef(l *lexer) (stateFn, error) {
+	if f := l.delimit(labelRefToken); f != nil {
+		return f, nil
+	}
+	return nil, lexError{l.start, fmt.Sprintf("malformed datum label %q: unexpected %q", string(l.buf), l.cur), false}
+}
+
+// lexes a boolean in progress.  We accept anything up to the next delimiter
+// and leave it up to atom to reject things that aren't #t, #f, #true or
+// #false.
+func lexBool(l *lexer) (stateFn, error) {
+	if f := l.delimit(boolToken); f != nil {
+		return f, nil
+	}
+	l.keep()
+	return lexBool, nil
+}
+
+// lexes the first rune of a character literal.  The first rune is always part
+// of the literal, even if it's something like a paren or a space, which is how
+// we read #\( and #\ .
+func lexChar(l *lexer) (stateFn, error) {
+	l.keep()
+	return lexCharName, nil
+}
+
+// lexes the remainder of a character literal, which might be a character name
+// like #\newline or a hex scalar value like #\x41.
+func lexCharName(l *lexer) (stateFn, error) {
+	if f := l.delimit(charToken); f != nil {
+		return f, nil
+	}
+	l.keep()
+	return lexCharName, nil
+}
+
+// lexes an in-progress string.  Basically we just keep all of the tokens until
+// we see a double-quote character, signifying the end of the string.  We also
+// switch into escape mode if we come across a backslash.
+func lexString(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '"':
+		l.emit(stringToken)
+		l.inString = false
+		return lexWhitespace, nil
+	case '\\':
+		return lexStringEsc, nil
+	}
+	l.keep()
+	return lexString, nil
+}
+
+// the single-character string escapes and the runes they stand for
+var stringEscapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'a':  '\a',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+}
+
+// lex the character *after* the string escape character \.  Most escapes are a
+// single character, but \x and \u start hex escapes, and a backslash at the
+// end of a line starts a line continuation.
+func lexStringEsc(l *lexer) (stateFn, error) {
+	if r, ok := stringEscapes[l.cur]; ok {
+		l.add(r)
+		return lexString, nil
+	}
+	switch l.cur {
+	case 'x':
+		l.escape, l.escapeLen = 0, 0
+		return lexStringHex, nil
+	case 'u':
+		l.escape, l.escapeLen = 0, 0
+		return lexStringUnicode, nil
+	case ' ', '\t':
+		return lexStringEscSpace, nil
+	case '\n':
+		return lexStringContinuation, nil
+	case '\r':
+		return lexStringEscSpace, nil
+	}
+	return nil, l.errorf("unknown escape sequence \\%c in string", l.cur)
+}
+
+// accumulates the current rune, which should be a hex digit, into the escape
+// sequence in progress.
+func (l *lexer) escapeDigit() error {
+	d, ok := hexValue(l.cur)
+	if !ok {
+		return l.errorf("invalid hex digit %q in string escape", l.cur)
+	}
+	l.escape = l.escape*16 + d
+	l.escapeLen++
+	return nil
+}
+
+// adds the completed escape sequence to the string in progress.
+func (l *lexer) addEscape() error {
+	if !utf8.ValidRune(l.escape) {
+		return l.errorf("invalid unicode scalar value %x in string escape", l.escape)
+	}
+	l.add(l.escape)
+	return nil
+}
+
+// lexes a hex escape like \x41;.  Any number of hex digits may appear, but the
+// escape has to be terminated by a semicolon.
+func lexStringHex(l *lexer) (stateFn, error) {
+	if l.cur == ';' && l.escapeLen > 0 {
+		if err := l.addEscape(); err != nil {
+			return nil, err
+		}
+		return lexString, nil
+	}
+	if l.escapeLen == 8 {
+		return nil, l.errorf("hex escape in string is too long")
+	}
+	if err := l.escapeDigit(); err != nil {
+		return nil, err
+	}
+	return lexStringHex, nil
+}
+
+// lexes a unicode escape like \u03bb, which always has exactly four hex
+// digits.
+func lexStringUnicode(l *lexer) (stateFn, error) {
+	if err := l.escapeDigit(); err != nil {
+		return nil, err
+	}
+	if l.escapeLen < 4 {
+		return lexStringUnicode, nil
+	}
+	if err := l.addEscape(); err != nil {
+		return nil, err
+	}
+	return lexString, nil
+}
+
+// lexes trailing whitespace after a backslash, which is only allowed if it's
+// followed by the end of the line.
+func lexStringEscSpace(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case ' ', '\t', '\r':
+		return lexStringEscSpace, nil
+	case '\n':
+		return lexStringContinuation, nil
+	}
+	return nil, l.errorf("unknown escape sequence in string: backslash followed by whitespace")
+}
+
+// lexes the leading whitespace on the line following a line continuation.  The
+// backslash, the line break and all of the whitespace around the line break is
+// dropped from the string.
+func lexStringContinuation(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case ' ', '\t':
+		return lexStringContinuation, nil
+	}
+	return lexString(l)
+}
+
+// returns the value of the hex digit r.
+func hexValue(r rune) (rune, bool) {
+	switch {
+	case '0' <= r && r <= '9':
+		return r - '0', true
+	case 'a' <= r && r <= 'f':
+		return r - 'a' + 10, true
+	case 'A' <= r && r <= 'F':
+		return r - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// lex an integer.  Once we're on an integer, the only valid characters are
+// delimiters, a period to indicate we want a float, an exponent marker, or more
+// digits.  Everything else is crap.
+func lexInt(l *lexer) (stateFn, error) {
+	if f := l.delimit(integerToken); f != nil {
+		return f, nil
+	}
+	switch l.cur {
+	case '.':
+		l.keep()
+		return lexFloat, nil
+	case 'e', 'E':
+		l.keep()
+		return lexExponent, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexInt, nil
+	}
+	return nil, l.badNumber()
+}
+
+// once we're in a float, the only valid values are digits, delimiters or an
+// exponent marker.
+func lexFloat(l *lexer) (stateFn, error) {
+	if f := l.delimit(floatToken); f != nil {
+		return f, nil
+	}
+	switch l.cur {
+	case 'e', 'E':
+		l.keep()
+		return lexExponent, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexFloat, nil
+	}
+	return nil, l.badNumber()
+}
+
+// lexes the rune after the exponent marker in something like 6.02e23.  The
+// exponent may be signed, but it has to have at least one digit.
+func lexExponent(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '+', '-':
+		l.keep()
+		return lexExponentSign, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexExponentDigits, nil
+	}
+	return nil, l.badNumber()
+}
+
+// lexes the rune after the sign of an exponent, which has to be a digit.
+func lexExponentSign(l *lexer) (stateFn, error) {
+	if isDigit(l.cur) {
+		l.keep()
+		return lexExponentDigits, nil
+	}
+	return nil, l.badNumber()
+}
+
+// lexes the digits of an exponent.
+func lexExponentDigits(l *lexer) (stateFn, error) {
+	if f := l.delimit(floatToken); f != nil {
+		return f, nil
+	}
+	if isDigit(l.cur) {
+		l.keep()
+		return lexExponentDigits, nil
+	}
+	return nil, l.badNumber()
+}
+
+// lexes a number with a radix or exactness prefix, like #xff or #e1.0.  The
+// digits that are valid depend on the prefixes, so we just take everything up
+// to the next delimiter and let atom sort it out.
+func lexPrefixedNumber(l *lexer) (stateFn, error) {
+	if f := l.delimit(numberToken); f != nil {
+		return f, nil
+	}
+	l.keep()
+	return lexPrefixedNumber, nil
+}
+
+// lexes a symbol in progress
+func lexSymbol(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case ' ', '\t', '\n', '\r', ')', ']', '}', ';':
+		l.emit(symbolToken)
+		return lexWhitespace(l)
+	default:
+		l.keep()
+		return lexSymbol, nil
+	}
+	panic("not reached")
+}
+
+// lex a close parenthesis
+func lexCloseParen(l *lexer) (stateFn, error) {
+	l.queue = append(l.queue, token{")", closeParenToken, l.pos()})
+	if l.depth > 0 {
+		l.depth--
+	}
+	return lexWhitespace, nil
+}
+
+// lexes an open bracket.  Brackets are read as parens, so [a b] is the same
+// list as (a b), but a bracket can only be closed by a bracket.
+func lexOpenBracket(l *lexer) (stateFn, error) {
+	l.queue = append(l.queue, token{"[", openParenToken, l.pos()})
+	l.depth++
+	return lexWhitespace, nil
+}
+
+// lexes a close bracket
+func lexCloseBracket(l *lexer) (stateFn, error) {
+	l.queue = append(l.queue, token{"]", closeParenToken, l.pos()})
+	if l.depth > 0 {
+		l.depth--
+	}
+	return lexWhitespace, nil
+}
+
+// lexes an open brace, which starts a hash table literal like {a 1 b 2}
+func lexOpenBrace(l *lexer) (stateFn, error) {
+	l.queue = append(l.queue, token{"{", openBraceToken, l.pos()})
+	l.depth++
+	return lexWhitespace, nil
+}
+
+// lexes a close brace
+func lexCloseBrace(l *lexer) (stateFn, error) {
+	l.queue = append(l.queue, token{"}", closeBraceToken, l.pos()})
+	if l.depth > 0 {
+		l.depth--
+	}
+	return lexWhitespace, nil
+}
+
+// lexes a comment
+func lexComment(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '\n', '\r':
+		return lexWhitespace, nil
+	}
+	return lexComment, nil
+}
+
+// lexes the inside of a block comment, #| like this |#.  Block comments nest,
+// so we have to watch out for both the start and the end of a block comment.
+func lexBlockComment(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '|':
+		return lexBlockCommentBar, nil
+	case '#':
+		return lexBlockCommentHash, nil
+	}
+	return lexBlockComment, nil
+}
+
+// lexes the rune after a | in a block comment, which might close the comment.
+func lexBlockCommentBar(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '#':
+		l.comments--
+		if l.comments == 0 {
+			return lexWhitespace, nil
+		}
+		return lexBlockComment, nil
+	case '|':
+		return lexBlockCommentBar, nil
+	}
+	return lexBlockComment, nil
+}
+
+// lexes the rune after a # in a block comment, which might open a nested
+// comment.
+func lexBlockCommentHash(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '|':
+		l.comments++
+		return lexBlockComment, nil
+	case '#':
+		return lexBlockCommentHash, nil
+	}
+	return lexBlockComment, nil
+}
+
+// skips input after a lex error until the start of the next top-level form,
+// so that one typo doesn't take down the rest of the input.  We count parens
+// to find the end of the form that the error was in, skipping over strings and
+// comments so that the parens inside of them aren't counted.
+func lexRecover(l *lexer) (stateFn, error) {
+	if l.inString {
+		return lexRecoverString(l)
+	}
+	switch l.cur {
+	case '(', '[', '{':
+		l.depth++
+	case ')', ']', '}':
+		l.depth--
+		if l.depth <= 0 {
+			l.depth = 0
+			return l.recovered()
+		}
+	case '"':
+		l.inString = true
+		return lexRecoverString, nil
+	case ';':
+		return lexRecoverComment, nil
+	case ' ', '\t', '\n', '\r':
+		if l.depth == 0 {
+			return l.recovered()
+		}
+	}
+	return lexRecover, nil
+}
+
+// skips the rest of a string while recovering from a lex error.
+func lexRecoverString(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '\\':
+		return lexRecoverStringEsc, nil
+	case '"':
+		l.inString = false
+		return lexRecover, nil
+	}
+	return lexRecoverString, nil
+}
+
+// skips an escaped rune in a string while recovering from a lex error, so that
+// an escaped double quote doesn't end the string.
+func lexRecoverStringEsc(l *lexer) (stateFn, error) {
+	return lexRecoverString, nil
+}
+
+// skips a comment while recovering from a lex error.
+func lexRecoverComment(l *lexer) (stateFn, error) {
+	switch l.cur {
+	case '\n', '\r':
+		return lexRecover(l)
+	}
+	return lexRecoverComment, nil
+}
+
+// returns the next token from the input, stepping the lexer until it has a
+// token to hand out.  Errors are handed out as error tokens, after which the
+// lexer skips ahead to the next top-level form and carries on.  Once the input
+// is used up, token returns an EOF token every time it's called.
+func (l *lexer) token() token {
+	for l.head == len(l.queue) {
+		l.queue, l.head = l.queue[:0], 0
+		if l.done {
+			return token{t: eofToken, pos: l.pos()}
+		}
+		l.step()
+	}
+	t := l.queue[l.head]
+	l.head++
+	return t
+}
+
+// moves on to the next rune of the input and runs the current state on it.
+// Runes are only read when they're needed, so once a token has come out, the
+// input after it is untouched.  That's what lets a reader macro take over the
+// input right after its macro character.
+func (l *lexer) step() {
+	if err := l.next(); err != nil {
+		l.finish(err)
+		return
+	}
+	f, err := l.state, l.checkLimits()
+	if err == nil {
+		f, err = l.state(l)
+	}
+	if e, ok := err.(lexError); ok {
+		l.fail(e)
+		l.recovering = true
+		f, _ = lexRecover(l)
+	}
+	l.state = f
+}
+
+// checks whether the input has gone over the lexer's limits.  The check is made
+// before the current rune is lexed, so that when we go over a limit, the rune
+// goes to lexRecover instead.  Nothing is checked while we're skipping the rest
+// of a datum after an error, so a datum that's too long is only reported once,
+// even though we keep going over the limit while skipping the rest of it.
+func (l *lexer) checkLimits() error {
+	if l.recovering {
+		return nil
+	}
+	if l.limits.MaxToken > 0 && l.lexing && len(l.buf) > l.limits.MaxToken {
+		return lexError{l.start, fmt.Sprintf("token is longer than %d bytes", l.limits.MaxToken), false}
+	}
+	if l.limits.MaxForm > 0 && l.form > l.limits.MaxForm {
+		return l.errorf("datum is longer than %d bytes", l.limits.MaxForm)
+	}
+	return nil
+}
+
+// wraps up lexing after reading from the input failed with err.  Whatever
+// token was in progress when the input ended is ended by a newline that
+// isn't really there, so input like "(+ 1 2)" without a trailing newline
+// doesn't lose its last token.
+func (l *lexer) finish(err error) {
+	l.done = true
+	switch {
+	case err != io.EOF:
+		l.fail(lexError{l.pos(), err.Error(), false})
+	case l.comments > 0:
+		l.fail(lexError{l.pos(), "unexpected EOF in block comment", true})
+	case l.inString:
+		l.fail(lexError{l.start, "unexpected EOF in string", true})
+	case l.lexing && string(l.buf) == `#\`:
+		l.fail(lexError{l.start, "unexpected EOF in character", true})
+	default:
+		l.advance('\n')
+		if _, err := l.state(l); err != nil {
+			l.fail(err.(lexError))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// type Macro is a reader macro.  When the reader runs into a macro character
+// at the start of a datum, it calls the character's macro, which reads
+// whatever it wants from the port and returns the datum that was read.  The
+// macro character itself has already been read.
+type Macro func(c Char, p *Port) (interface{}, error)
+
+// the characters that can't be macro characters, since the reader needs them
+// for its own syntax.
+const reservedChars = `()[]{}";'` + "`" + `,#`
+
+// the characters other than letters and digits that can start a symbol or a
+// number.  Making one of them a macro character would change how the symbols
+// and numbers that start with it are read.
+const symbolChars = `!$%&*/:<=>?^_~+-.`
+
+// checks whether c can be used as a macro character.
+func CheckMacroChar(c Char) error {
+	r := rune(c)
+	switch {
+	case unicode.IsSpace(r) || strings.ContainsRune(reservedChars, r):
+		return fmt.Errorf("%v can't be a macro character", c)
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(symbolChars, r):
+		return fmt.Errorf("%v can't be a macro character, since it can start a symbol or a number", c)
+	}
+	return nil
+}
+
+// type Port is the input of a reader macro.  A port reads from the same input
+// as the reader that called the macro, picking up right after the macro
+// character, and it can only be used while the macro is running.
+type Port struct {
+	r      *Reader
+	closed bool
+}
+
+func (p *Port) String() string {
+	return "#<port>"
+}
+
+var errPortClosed = errors.New("port is closed: reader macros can only read while they're running")
+
+// reads the next character from the port.  At the end of the input, ReadChar
+// returns io.EOF.
+func (p *Port) ReadChar() (Char, error) {
+	if p.closed {
+		return 0, errPortClosed
+	}
+	if err := p.r.lex.next(); err != nil {
+		return 0, err
+	}
+	return Char(p.r.lex.cur), nil
+}
+
+// returns the next character from the port without reading it.  At the end
+// of the input, PeekChar returns io.EOF.
+func (p *Port) PeekChar() (Char, error) {
+	if p.closed {
+		return 0, errPortClosed
+	}
+	c, _, err := p.r.lex.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	return Char(c), p.r.lex.in.UnreadRune()
+}
+
+// reads the next datum from the port.  At the end of the input, Read returns
+// io.EOF.
+func (p *Port) Read() (interface{}, error) {
+	if p.closed {
+		return nil, errPortClosed
+	}
+	v, _, err := p.r.parse()
+	return v, err
+}
+
+// calls the macro for the macro character that the token t is made out of.
+func (r *Reader) expand(t token) (interface{}, error) {
+	c, _ := utf8.DecodeRuneInString(t.lexeme)
+	m, ok := r.Macros[c]
+	if !ok {
+		return nil, errorAt(t.pos, fmt.Errorf("no reader macro for %v", Char(c)))
+	}
+	if err := r.descend(t); err != nil {
+		return nil, err
+	}
+	defer r.ascend()
+	p := &Port{r: r}
+	v, err := m(Char(c), p)
+	p.closed = true
+	return v, errorAt(t.pos, err)
+}
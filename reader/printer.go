@@ -0,0 +1,261 @@
+package reader
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// formats v the way that Scheme's write procedure does, which is a form that
+// can be read back in to get an equal value: strings are quoted, characters
+// are written as #\a and so on.  Lists and vectors that contain themselves
+// are written with datum labels, e.g. #0=(1 2 . #0#), so that printing them
+// terminates.  Values that have no written form, like procedures, are printed
+// with fmt.
+func Write(v interface{}) string {
+	return format(v, true)
+}
+
+// formats v the way that Scheme's display procedure does, which is the way a
+// person would want to read it: strings and characters are printed as their
+// bare contents, but otherwise display is the same as Write.
+func Display(v interface{}) string {
+	return format(v, false)
+}
+
+func format(v interface{}, write bool) string {
+	p := printer{write: write, labels: cycles(v)}
+	p.print(v)
+	return p.buf.String()
+}
+
+type printer struct {
+	buf   bytes.Buffer
+	write bool // whether we're writing or displaying
+
+	// the datum labels of the data that are part of a cycle.  A label is -1
+	// until the first time its datum is printed.
+	labels map[interface{}]int
+	next   int // the next label to hand out
+}
+
+// type literal is text that the printer has left to write out as it is, like
+// the closing paren of a list.
+type literal string
+
+// prints v.  The things inside of lists, vectors and hash tables are put on a
+// stack of things left to print instead of being printed by recursion, so
+// that printing deeply nested data doesn't need a deep Go stack.
+func (p *printer) print(v interface{}) {
+	todo := []interface{}{v}
+	for len(todo) > 0 {
+		v := todo[len(todo)-1]
+		todo = p.printOne(v, todo[:len(todo)-1])
+	}
+}
+
+// prints v, pushing whatever it contains onto todo, and returns the new todo
+// stack.  Since todo is popped from the end, the parts are pushed in reverse.
+func (p *printer) printOne(v interface{}, todo []interface{}) []interface{} {
+	switch t := v.(type) {
+	case literal:
+		p.buf.WriteString(string(t))
+	case *Pair:
+		if p.label(t) {
+			return todo
+		}
+		p.buf.WriteByte('(')
+		items := []interface{}{t.Car}
+		v = t.Cdr
+		for {
+			next, ok := v.(*Pair)
+			if !ok {
+				break
+			}
+			// a labeled pair has to be written out on its own, so the list
+			// is written as a dotted pair at that point.
+			if _, labeled := p.labels[next]; labeled {
+				break
+			}
+			items = append(items, next.Car)
+			v = next.Cdr
+		}
+		todo = append(todo, literal(")"))
+		if v != Null {
+			todo = append(todo, v, literal(" . "))
+		}
+		return pushItems(todo, items)
+	case *Vector:
+		if p.label(t) {
+			return todo
+		}
+		p.buf.WriteString("#(")
+		return pushItems(append(todo, literal(")")), t.Items)
+	case *HashTable:
+		if p.label(t) {
+			return todo
+		}
+		p.buf.WriteByte('{')
+		var items []interface{}
+		t.Each(func(key, value interface{}) {
+			items = append(items, key, value)
+		})
+		return pushItems(append(todo, literal("}")), items)
+	case string:
+		if p.write {
+			p.buf.WriteString(quote(t))
+		} else {
+			p.buf.WriteString(t)
+		}
+	case Char:
+		if p.write {
+			p.buf.WriteString(t.String())
+		} else {
+			p.buf.WriteRune(rune(t))
+		}
+	case bool:
+		if t {
+			p.buf.WriteString("#t")
+		} else {
+			p.buf.WriteString("#f")
+		}
+	case float64:
+		p.buf.WriteString(formatFloat(t))
+	default:
+		fmt.Fprint(&p.buf, v)
+	}
+	return todo
+}
+
+// pushes items onto todo so that they're printed in order, separated by
+// spaces.
+func pushItems(todo []interface{}, items []interface{}) []interface{} {
+	for i := len(items) - 1; i >= 0; i-- {
+		todo = append(todo, items[i])
+		if i > 0 {
+			todo = append(todo, literal(" "))
+		}
+	}
+	return todo
+}
+
+// prints the datum label of x, if x has one.  The first time x is printed
+// its label is defined, as in #0=, and x itself still has to be printed.
+// After that, x is printed as a reference to its label, as in #0#, and label
+// returns true to say that x has been taken care of.
+func (p *printer) label(x interface{}) bool {
+	n, ok := p.labels[x]
+	if !ok {
+		return false
+	}
+	if n >= 0 {
+		fmt.Fprintf(&p.buf, "#%d#", n)
+		return true
+	}
+	p.labels[x] = p.next
+	fmt.Fprintf(&p.buf, "#%d=", p.next)
+	p.next++
+	return false
+}
+
+// finds the pairs, vectors and hash tables in v that are part of a cycle,
+// which are the ones that need datum labels to be printed.  A datum is part
+// of a cycle if we run into it again while we're still looking at the things
+// inside of it.  The labels all start out as -1, since none of them have been
+// printed yet.
+func cycles(v interface{}) map[interface{}]int {
+	var labels map[interface{}]int
+	active := make(map[interface{}]bool)
+	done := make(map[interface{}]bool)
+
+	// the things left to look at are kept on a stack instead of being looked
+	// at by recursion, so that deeply nested data don't need a deep Go
+	// stack.  A datum stays active until the finished marker that's pushed
+	// under the things inside of it comes back off of the stack.
+	type finished struct{ v interface{} }
+	todo := []interface{}{v}
+	for len(todo) > 0 {
+		v := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		switch t := v.(type) {
+		case finished:
+			delete(active, t.v)
+			done[t.v] = true
+		case *Pair, *Vector, *HashTable:
+			if active[t] {
+				if labels == nil {
+					labels = make(map[interface{}]int)
+				}
+				labels[t] = -1
+				continue
+			}
+			if done[t] {
+				continue
+			}
+			active[t] = true
+			todo = append(todo, finished{t})
+			switch t := t.(type) {
+			case *Pair:
+				todo = append(todo, t.Cdr, t.Car)
+			case *Vector:
+				todo = append(todo, t.Items...)
+			case *HashTable:
+				t.Each(func(key, value interface{}) {
+					todo = append(todo, key, value)
+				})
+			}
+		}
+	}
+	return labels
+}
+
+// the escapes used for special characters in written strings.  The lexer
+// understands all of them.
+var quoteEscapes = map[rune]string{
+	'"':  `\"`,
+	'\\': `\\`,
+	'\n': `\n`,
+	'\t': `\t`,
+	'\r': `\r`,
+	'\a': `\a`,
+	0:    `\0`,
+}
+
+// quotes the string s for write, escaping the characters that can't appear in
+// a string literal as they are.
+func quote(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		if esc, ok := quoteEscapes[r]; ok {
+			buf.WriteString(esc)
+		} else if !unicode.IsPrint(r) {
+			fmt.Fprintf(&buf, `\x%x;`, r)
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// formats a float so that it reads back as a float.  Go would print 1.0 as 1,
+// which reads back as an integer.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	case math.IsNaN(f):
+		return "+nan.0"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
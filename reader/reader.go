@@ -0,0 +1,752 @@
+// Package reader reads lispy source text into data.
+//
+// A Reader pulls runes from its input only as fast as data are asked for, so
+// reading can stop at any point without leaving anything running in the
+// background.  The data are made out of the following types:
+//
+//	int64, float64    numbers
+//	string            strings
+//	bool              #t and #f
+//	Char              characters, like #\a
+//	Symbol            symbols
+//	*Pair, EmptyList  lists, like (a b . c) and ()
+//	*Vector           vectors, like #(1 2 3)
+//	*HashTable        hash tables, like {a 1 b 2}
+//
+// Reader shorthands are expanded as they're read, so 'x is read as the list
+// (quote x).  Datum labels let a datum refer to itself, the way that the
+// printer writes cyclic data: #0=(a . #0#) is a list that goes around forever.
+package reader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// type Reader reads data from some lispy source input, one at a time.
+type Reader struct {
+	// Filename is used to describe the positions of data and errors.
+	Filename string
+
+	// Macros maps macro characters to their reader macros.  A macro
+	// character only acts as one at the start of a datum, so it can still
+	// appear inside of symbols.
+	Macros map[rune]Macro
+
+	// Limits bounds how much input the reader will take on for one datum.
+	// It starts out as DefaultLimits.
+	Limits Limits
+
+	lex    *lexer
+	pos    Pos
+	depth  int            // how many data deep we are
+	labels map[int]*label // the datum labels in the datum being read
+}
+
+// type Limits bounds the input that a Reader will accept, so that input from
+// somewhere untrusted can't run the process out of stack or memory.  A limit
+// of 0 means that there's no limit.  Input that goes over a limit is a syntax
+// error, which the reader skips over like any other.
+type Limits struct {
+	MaxDepth int // how many lists, vectors, tables and quotes deep a datum can go
+	MaxToken int // how many bytes long a single token can be
+	MaxForm  int // how many bytes of input a top-level datum can take up
+}
+
+// the Limits that new Readers start out with.  Only the depth is limited to
+// begin with, since data are read by recursion, and running out of Go stack
+// kills the whole process instead of being an error.
+var DefaultLimits = Limits{MaxDepth: 1000}
+
+// creates a Reader that reads from r.  If r isn't already an io.RuneScanner,
+// it is buffered.
+func New(r io.Reader) *Reader {
+	rs, ok := r.(io.RuneScanner)
+	if !ok {
+		rs = bufio.NewReader(r)
+	}
+	return &Reader{Limits: DefaultLimits, lex: newLexer(rs)}
+}
+
+// reads the next datum from the input.  At the end of the input, Next returns
+// io.EOF.  Syntax errors are returned as values of type Error.  The reader
+// skips ahead to the next datum after a syntax error, so it's fine to keep
+// calling Next after one.
+func (r *Reader) Next() (interface{}, error) {
+	r.lex.file = r.Filename
+	r.lex.macros = r.Macros
+	r.lex.limits = r.Limits
+	// the lexer might still be skipping the rest of the last datum after an
+	// error, in which case this datum is counted from wherever that ends.
+	if !r.lex.recovering {
+		r.lex.form = 0
+	}
+	r.labels = nil
+	v, pos, err := r.parse()
+	r.pos = pos
+	return v, err
+}
+
+// makes the reader number the lines of its input starting at line, rather
+// than at 1, for input that was cut out of the middle of something bigger,
+// like the lines of a REPL session.  It has to be called before the first call
+// to Next.
+func (r *Reader) SetLine(line int) {
+	r.lex.line = line
+}
+
+// the position at which the datum most recently returned by Next started.
+func (r *Reader) Pos() Pos {
+	return r.pos
+}
+
+// parses the string lexeme into a value that can be eval'd
+func atom(t token) (interface{}, error) {
+	switch t.t {
+	case integerToken:
+		val, err := strconv.ParseInt(t.lexeme, 10, 64)
+		if err != nil {
+			return nil, numberError(t.lexeme, err)
+		}
+		return val, nil
+
+	case floatToken:
+		val, err := strconv.ParseFloat(t.lexeme, 64)
+		if err != nil {
+			return nil, numberError(t.lexeme, err)
+		}
+		return val, nil
+
+	case numberToken:
+		return parseNumber(t.lexeme)
+
+	case stringToken:
+		return t.lexeme, nil
+
+	case charToken:
+		return parseChar(t.lexeme)
+
+	case boolToken:
+		switch t.lexeme {
+		case "#t", "#true":
+			return true, nil
+		case "#f", "#false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean %s", t.lexeme)
+
+	case symbolToken:
+		return Symbol(t.lexeme), nil
+	}
+
+	return nil, fmt.Errorf("unable to atomize token: %v", t)
+}
+
+// parses a number that has radix or exactness prefixes, e.g. #xff, #b1010 or
+// #e#x10.  Prefixes may appear in any order, but each kind may only appear
+// once.  Non-decimal numbers must be integers.
+func parseNumber(lexeme string) (interface{}, error) {
+	bad := func() (interface{}, error) {
+		return nil, fmt.Errorf("malformed number %q", lexeme)
+	}
+
+	radix, exactness := 0, byte(0)
+	body := lexeme
+	for len(body) >= 2 && body[0] == '#' {
+		switch p := body[1] | 0x20; p {
+		case 'x', 'b', 'o', 'd':
+			if radix != 0 {
+				return bad()
+			}
+			radix = map[byte]int{'x': 16, 'b': 2, 'o': 8, 'd': 10}[p]
+		case 'e', 'i':
+			if exactness != 0 {
+				return bad()
+			}
+			exactness = p
+		default:
+			return bad()
+		}
+		body = body[2:]
+	}
+	if radix == 0 {
+		radix = 10
+	}
+
+	var v interface{}
+	n, err := strconv.ParseInt(body, radix, 64)
+	switch {
+	case err == nil:
+		v = n
+	case radix == 10 && isDecimal(body):
+		f, err := strconv.ParseFloat(body, 64)
+		if err != nil {
+			return nil, numberError(lexeme, err)
+		}
+		v = f
+	default:
+		return nil, numberError(lexeme, err)
+	}
+
+	switch exactness {
+	case 'i':
+		if n, ok := v.(int64); ok {
+			return float64(n), nil
+		}
+	case 'e':
+		if f, ok := v.(float64); ok {
+			if f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+				return nil, fmt.Errorf("number %q has no exact representation", lexeme)
+			}
+			return int64(f), nil
+		}
+	}
+	return v, nil
+}
+
+// the error for a number that strconv couldn't parse.  Numbers that are too
+// big to fit are well formed, so they get an error that says so instead.
+func numberError(lexeme string, err error) error {
+	if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+		return fmt.Errorf("number %q is out of range", lexeme)
+	}
+	return fmt.Errorf("malformed number %q", lexeme)
+}
+
+// checks that s is a decimal number in the syntax that the lexer accepts:
+// an optional sign, digits with at most one period, and an optional exponent.
+// strconv.ParseFloat is more lenient than that, accepting things like "inf"
+// and hex floats.
+func isDecimal(s string) bool {
+	mantissa, exponent := trimSign(s), ""
+	if i := strings.IndexAny(mantissa, "eE"); i >= 0 {
+		mantissa, exponent = mantissa[:i], mantissa[i+1:]
+		if !isDigits(trimSign(exponent)) {
+			return false
+		}
+	}
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		mantissa = mantissa[:i] + mantissa[i+1:]
+	}
+	return isDigits(mantissa)
+}
+
+func trimSign(s string) string {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		return s[1:]
+	}
+	return s
+}
+
+// checks that s is a nonempty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// turns an error token back into the error that the lexer ran into.
+func lexed(t token) error {
+	return Error{Pos: t.pos, Msg: t.lexeme, Incomplete: t.t == incompleteToken, lexed: true}
+}
+
+// creates an error for input that ended in the middle of a datum.
+func unexpectedEOF(p Pos, format string, args ...interface{}) error {
+	return Error{Pos: p, Msg: fmt.Sprintf(format, args...), Incomplete: true}
+}
+
+// reports whether src is made up of complete data, that is, whether all of it
+// can be read without running out of input in the middle of a datum.  Input
+// that has syntax errors in it counts as complete, since reading more input
+// won't fix them.
+func Complete(src string) bool {
+	r := New(strings.NewReader(src))
+	for {
+		_, err := r.Next()
+		switch e := err.(type) {
+		case nil:
+		case Error:
+			if e.Incomplete {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// type Completer tells whether input that comes in a piece at a time, like
+// lines typed at a prompt, is complete.  Unlike calling Complete on all of the
+// input every time a piece comes in, a Completer only looks at each piece
+// once, by keeping the lexer's state and counting open lists from one piece
+// to the next.  Syntax errors that only the reader would catch, like a
+// misplaced dot, don't count as complete until the lists around them are
+// closed.
+type Completer struct {
+	lex      *lexer
+	depth    int // how many lists, vectors and hash tables are open
+	prefixes int // how many quotes, labels and datum comments at the top level are waiting on a datum
+}
+
+func NewCompleter() *Completer {
+	c := new(Completer)
+	c.Reset()
+	return c
+}
+
+// forgets all of the input given so far.
+func (c *Completer) Reset() {
+	*c = Completer{lex: newLexer(nil)}
+}
+
+// adds src to the input and reports whether all of the input given since the
+// last Reset is complete, in the same sense as Complete.
+func (c *Completer) Add(src string) bool {
+	in := strings.NewReader(src)
+	c.lex.in = in
+	for in.Len() > 0 {
+		c.lex.step()
+	}
+	c.take(c.lex)
+
+	// to find out what would happen if the input ended here, a copy of the
+	// lexer is run to the end instead, so that more input can still be added.
+	l := *c.lex
+	l.buf = append([]byte(nil), l.buf...)
+	l.queue = nil
+	l.finish(io.EOF)
+	end := *c
+	return end.take(&l) && end.depth == 0 && end.prefixes == 0
+}
+
+// counts the lists and prefixes in the tokens that l has lexed, and reports
+// whether none of them said that the input ended too soon.
+func (c *Completer) take(l *lexer) bool {
+	ok := true
+	for _, t := range l.queue[l.head:] {
+		switch t.t {
+		case openParenToken, openBraceToken, vectorToken:
+			c.depth++
+		case closeParenToken, closeBraceToken:
+			if c.depth > 0 {
+				c.depth--
+			}
+			if c.depth == 0 {
+				c.prefixes = 0
+			}
+		case quoteToken, quasiquoteToken, unquoteToken, unquoteSplicingToken, datumCommentToken, labelToken:
+			if c.depth == 0 {
+				c.prefixes++
+			}
+		case errorToken:
+			// the rest of the datum is skipped after an error.
+			c.depth, c.prefixes = 0, 0
+		case incompleteToken:
+			ok = false
+		default:
+			if c.depth == 0 {
+				c.prefixes = 0
+			}
+		}
+	}
+	l.queue, l.head = l.queue[:0], 0
+	return ok
+}
+
+// the lexemes of the closing tokens that match each opening token.
+var closers = map[string]string{
+	"(":  ")",
+	"[":  "]",
+	"#(": ")",
+	"{":  "}",
+}
+
+// checks that the closing token t matches the opening token open.  Either
+// way, t closes the innermost open list, vector or hash table, so there's
+// nothing left to discard if they don't match.
+func matching(open, t token) error {
+	if t.lexeme != closers[open.lexeme] {
+		return errorAt(t.pos, fmt.Errorf("unexpected %s, expected a match for %s at %v", t.lexeme, open.lexeme, open.pos))
+	}
+	return nil
+}
+
+// reads in tokens until a matching close paren or brace is found, returning
+// the items that were read.  If dotted is true, the list may end with a
+// dotted tail, as in (a b . c), which is returned as tail; otherwise the tail
+// is always Null.  If an error is encountered, the rest of the list is
+// discarded so that reading can pick up again at the next value.
+func (r *Reader) readIn(open token, dotted bool) ([]interface{}, interface{}, error) {
+	if err := r.descend(open); err != nil {
+		return nil, nil, r.abandon(err, 1)
+	}
+	defer r.ascend()
+	items := make([]interface{}, 0, 8)
+	for {
+		t, err := r.nextToken()
+		if err != nil {
+			return nil, nil, r.abandon(err, 1)
+		}
+		switch t.t {
+		case eofToken:
+			return nil, nil, unexpectedEOF(open.pos, "unexpected EOF, expected a match for %s", open.lexeme)
+		case closeParenToken, closeBraceToken:
+			if err := matching(open, t); err != nil {
+				return nil, nil, err
+			}
+			return items, Null, nil
+		case dotToken:
+			if !dotted || len(items) == 0 {
+				return nil, nil, r.abandon(errorAt(t.pos, errors.New("unexpected . in read")), 1)
+			}
+			tail, err := r.readTail(open, t)
+			if err != nil {
+				return nil, nil, err
+			}
+			return items, tail, nil
+		}
+		v, err := r.parseToken(t)
+		if err != nil {
+			return nil, nil, r.abandon(err, 1)
+		}
+		items = append(items, v)
+	}
+}
+
+// reads the tail of a dotted list, which has to be exactly one value followed
+// by the list's closing paren.
+func (r *Reader) readTail(open, dot token) (interface{}, error) {
+	t, err := r.nextToken()
+	if err != nil {
+		return nil, r.abandon(err, 1)
+	}
+	switch t.t {
+	case eofToken:
+		return nil, unexpectedEOF(dot.pos, "unexpected EOF after .")
+	case closeParenToken, closeBraceToken:
+		return nil, errorAt(t.pos, errors.New("expected a value after ."))
+	}
+	tail, err := r.parseToken(t)
+	if err != nil {
+		return nil, r.abandon(err, 1)
+	}
+
+	t, err = r.nextToken()
+	if err != nil {
+		return nil, r.abandon(err, 1)
+	}
+	switch t.t {
+	case eofToken:
+		return nil, unexpectedEOF(dot.pos, "unexpected EOF after .")
+	case closeParenToken, closeBraceToken:
+		if err := matching(open, t); err != nil {
+			return nil, err
+		}
+		return tail, nil
+	case errorToken, incompleteToken:
+		return nil, lexed(t)
+	case openParenToken, vectorToken, openBraceToken:
+		return nil, r.abandon(errorAt(t.pos, errors.New("expected ) after dotted tail")), 2)
+	}
+	return nil, r.abandon(errorAt(t.pos, errors.New("expected ) after dotted tail")), 1)
+}
+
+// reads the next token, skipping over datum comments and the data that they
+// comment out.
+func (r *Reader) nextToken() (token, error) {
+	for {
+		t := r.lex.token()
+		if t.t != datumCommentToken {
+			return t, nil
+		}
+		if err := r.skipDatum(t); err != nil {
+			return t, err
+		}
+	}
+}
+
+// goes one datum deeper into the input for the datum that starts with token
+// t, unless that's deeper than the reader's depth limit.  Going too deep
+// skips the rest of the top-level datum, since there's no telling how much
+// deeper it goes.  Each call to descend that succeeds has to be followed by a
+// call to ascend.
+func (r *Reader) descend(t token) error {
+	if r.Limits.MaxDepth > 0 && r.depth >= r.Limits.MaxDepth {
+		r.lex.skip()
+		return Error{Pos: t.pos, Msg: fmt.Sprintf("%s is nested more than %d deep", t.lexeme, r.Limits.MaxDepth), lexed: true}
+	}
+	r.depth++
+	return nil
+}
+
+// comes back out of a datum that we descended into.
+func (r *Reader) ascend() {
+	r.depth--
+}
+
+// abandons the list being read in after the error err, discarding the tokens
+// up to the list's closing paren.  Depth is the number of closing parens that
+// we're waiting on.  Lex errors are skipped over by the lexer itself, so
+// there's nothing to discard for those.  If we run into a lex error while
+// discarding, the lexer has already skipped ahead to the next top-level form,
+// so we stop and return that error instead.
+func (r *Reader) abandon(err error, depth int) error {
+	if e, ok := err.(Error); ok && e.lexed {
+		return err
+	}
+	for depth > 0 {
+		t := r.lex.token()
+		switch t.t {
+		case eofToken:
+			return err
+		case openParenToken, vectorToken, openBraceToken:
+			depth++
+		case closeParenToken, closeBraceToken:
+			depth--
+		case errorToken, incompleteToken:
+			return lexed(t)
+		}
+	}
+	return err
+}
+
+// maps the reader shorthand tokens to the symbols of the forms they expand
+// to.  E.g., 'x is read as (quote x).
+var shorthands = map[tokenType]Symbol{
+	quoteToken:           "quote",
+	quasiquoteToken:      "quasiquote",
+	unquoteToken:         "unquote",
+	unquoteSplicingToken: "unquote-splicing",
+}
+
+// parses the value that starts with token t, reading any additional tokens
+// that value needs.
+func (r *Reader) parseToken(t token) (interface{}, error) {
+	switch t.t {
+	case closeParenToken:
+		return nil, errorAt(t.pos, errors.New("unexpected close paren in read"))
+	case closeBraceToken:
+		return nil, errorAt(t.pos, errors.New("unexpected close brace in read"))
+	case dotToken:
+		return nil, errorAt(t.pos, errors.New("unexpected . in read"))
+	case errorToken, incompleteToken:
+		return nil, lexed(t)
+	case openParenToken:
+		items, tail, err := r.readIn(t, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return Null, nil
+		}
+		p := ListFrom(items, tail).(*Pair)
+		p.Pos = t.pos
+		return p, nil
+	case vectorToken:
+		items, _, err := r.readIn(t, false)
+		if err != nil {
+			return nil, err
+		}
+		return &Vector{Items: items}, nil
+	case openBraceToken:
+		items, _, err := r.readIn(t, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(items)%2 != 0 {
+			return nil, errorAt(t.pos, errors.New("hash table literal has a key with no value"))
+		}
+		table := NewHashTable()
+		for i := 0; i < len(items); i += 2 {
+			table.Set(items[i], items[i+1])
+		}
+		return table, nil
+	case macroToken:
+		return r.expand(t)
+	case labelToken:
+		return r.readLabeled(t)
+	case labelRefToken:
+		return r.labelRef(t)
+	case quoteToken, quasiquoteToken, unquoteToken, unquoteSplicingToken:
+		if err := r.descend(t); err != nil {
+			return nil, err
+		}
+		defer r.ascend()
+		v, _, err := r.parse()
+		if err == io.EOF {
+			return nil, unexpectedEOF(t.pos, "unexpected EOF after %s", t.lexeme)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Pair{Car: shorthands[t.t], Cdr: &Pair{Car: v, Cdr: Null}, Pos: t.pos}, nil
+	default:
+		v, err := atom(t)
+		return v, errorAt(t.pos, err)
+	}
+}
+
+// parses one datum, returning the position that the datum started at.
+func (r *Reader) parse() (interface{}, Pos, error) {
+	t, err := r.nextToken()
+	if err != nil {
+		return nil, t.pos, err
+	}
+	if t.t == eofToken {
+		return nil, Pos{}, io.EOF
+	}
+	v, err := r.parseToken(t)
+	return v, t.pos, err
+}
+
+// skips the datum following the datum comment token t.  Whatever follows the
+// #; is parsed and thrown away, so it has to be a complete datum.
+func (r *Reader) skipDatum(t token) error {
+	if err := r.descend(t); err != nil {
+		return err
+	}
+	defer r.ascend()
+	_, _, err := r.parse()
+	if err == io.EOF {
+		return unexpectedEOF(t.pos, "unexpected EOF after #;")
+	}
+	return err
+}
+
+// type label is a datum label, like the #0= in #0=(a . #0#).  A datum can
+// refer to its own label, but since the datum doesn't exist until it's been
+// read, references that are read before then are read as the label itself,
+// and they're replaced with the datum once it's finished.
+type label struct {
+	value interface{}
+	done  bool // whether value has been read yet
+	refs  bool // whether the label was referred to before it was done
+}
+
+// the number of the label token or label reference token t, e.g. 12 for #12=.
+func labelNumber(t token) (int, error) {
+	n, err := strconv.Atoi(t.lexeme[1 : len(t.lexeme)-1])
+	if err != nil {
+		return 0, errorAt(t.pos, fmt.Errorf("malformed datum label %q", t.lexeme))
+	}
+	return n, nil
+}
+
+// reads the datum labeled by the label token t.
+func (r *Reader) readLabeled(t token) (interface{}, error) {
+	n, err := labelNumber(t)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := r.labels[n]; ok {
+		return nil, errorAt(t.pos, fmt.Errorf("datum label #%d= is defined more than once", n))
+	}
+	if err := r.descend(t); err != nil {
+		return nil, err
+	}
+	defer r.ascend()
+	if r.labels == nil {
+		r.labels = make(map[int]*label)
+	}
+	l := new(label)
+	r.labels[n] = l
+	v, _, err := r.parse()
+	if err == io.EOF {
+		return nil, unexpectedEOF(t.pos, "unexpected EOF after %s", t.lexeme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v == l {
+		return nil, errorAt(t.pos, fmt.Errorf("datum label #%d= labels nothing but itself", n))
+	}
+	l.value, l.done = v, true
+	if l.refs {
+		l.patch(v)
+	}
+	return v, nil
+}
+
+// reads the label reference token t, like #0#, as the datum that it refers
+// to.
+func (r *Reader) labelRef(t token) (interface{}, error) {
+	n, err := labelNumber(t)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := r.labels[n]
+	if !ok {
+		return nil, errorAt(t.pos, fmt.Errorf("undefined datum label %s", t.lexeme))
+	}
+	if !l.done {
+		l.refs = true
+		return l, nil
+	}
+	return l.value, nil
+}
+
+// replaces the references to l that were read as part of its datum v with v
+// itself.  v can contain itself by now, so we keep track of what we've seen,
+// and the pairs, vectors and hash tables that we haven't seen yet are kept on
+// a stack instead of being walked by recursion.  Hash tables are rebuilt at
+// the end, since the hashes of their keys might have changed.
+func (l *label) patch(v interface{}) {
+	seen := make(map[interface{}]bool)
+	var todo []interface{}
+	var tables []*HashTable
+	resolve := func(x interface{}) interface{} {
+		if x == l {
+			x = l.value
+		}
+		switch x.(type) {
+		case *Pair, *Vector, *HashTable:
+			if !seen[x] {
+				seen[x] = true
+				todo = append(todo, x)
+			}
+		}
+		return x
+	}
+	resolve(v)
+	for len(todo) > 0 {
+		v := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		switch t := v.(type) {
+		case *Pair:
+			t.Car = resolve(t.Car)
+			t.Cdr = resolve(t.Cdr)
+		case *Vector:
+			for i, item := range t.Items {
+				t.Items[i] = resolve(item)
+			}
+		case *HashTable:
+			t.Each(func(key, value interface{}) {
+				resolve(key)
+				resolve(value)
+			})
+			tables = append(tables, t)
+		}
+	}
+	for _, t := range tables {
+		var keys, values []interface{}
+		t.Each(func(key, value interface{}) {
+			keys = append(keys, resolve(key))
+			values = append(values, resolve(value))
+		})
+		*t = *NewHashTable()
+		for i := range keys {
+			t.Set(keys[i], values[i])
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package reader
+
+import (
+	"io"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// reads everything in src, returning the data that were read, written back
+// out, and the messages of the errors that were hit along the way.
+func readAll(t *testing.T, src string) (data []string, errs []string) {
+	return readAllLimits(t, src, DefaultLimits)
+}
+
+// reads everything in src like readAll does, with the given limits.
+func readAllLimits(t *testing.T, src string, limits Limits) (data []string, errs []string) {
+	r := New(strings.NewReader(src))
+	r.Limits = limits
+	for i := 0; ; i++ {
+		if i > 1000 {
+			t.Fatalf("reader is stuck on %q", src)
+		}
+		v, err := r.Next()
+		switch err.(type) {
+		case nil:
+			data = append(data, Write(v))
+		case Error:
+			errs = append(errs, err.(Error).Msg)
+		default:
+			if err != io.EOF {
+				t.Fatalf("unexpected error reading %q: %v", src, err)
+			}
+			return data, errs
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"", nil},
+		{"1 -2 3.5 .5", []string{"1", "-2", "3.5", "0.5"}},
+		{`"a\nb" #\a #\space #t #f`, []string{`"a\nb"`, `#\a`, `#\space`, "#t", "#f"}},
+		{"foo ... +", []string{"foo", "...", "+"}},
+		{"(1 (2 3) . 4)", []string{"(1 (2 3) . 4)"}},
+		{"[a b] ()", []string{"(a b)", "()"}},
+		{"#(1 #(2)) 'x `(a ,b ,@c)", []string{"#(1 #(2))", "(quote x)", "(quasiquote (a (unquote b) (unquote-splicing c)))"}},
+		{"; comment\n1 #| block #| nested |# |# 2 #;(skipped) 3", []string{"1", "2", "3"}},
+		{"#xff #b101 #e1.0", []string{"255", "5", "1"}},
+		{"(#0=(a) #0#) #0=(1 . #0#) '#1=#(#1#)", []string{"((a) (a))", "#0=(1 . #0#)", "(quote #0=#(#0#))"}},
+	}
+	for _, test := range tests {
+		data, errs := readAll(t, test.src)
+		if len(errs) > 0 {
+			t.Errorf("%q: unexpected errors %v", test.src, errs)
+		}
+		if strings.Join(data, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%q: read %v, want %v", test.src, data, test.want)
+		}
+	}
+}
+
+func TestNextPos(t *testing.T) {
+	r := New(strings.NewReader("1\n  (a\n b)"))
+	r.Filename = "test"
+	want := []string{"test:1:1", "test:2:3"}
+	for _, w := range want {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Pos().String(); got != w {
+			t.Errorf("datum is at %s, want %s", got, w)
+		}
+	}
+}
+
+// after a syntax error, the reader should skip the rest of the datum that the
+// error was in and carry on with the next one.
+func TestResync(t *testing.T) {
+	tests := []struct {
+		src    string
+		want   []string
+		errors int
+	}{
+		{"(1 2x 3) (a b)", []string{"(a b)"}, 1},
+		{"(1 . 2 3) ok", []string{"ok"}, 1},
+		{") after", []string{"after"}, 1},
+		{"(a ] (b)", []string{"(b)"}, 1},
+		{`(a "str)" #\bogus b) c`, []string{"c"}, 1},
+		{"1x 2y 3", []string{"3"}, 2},
+		{"(a (b", nil, 1},
+		{`"unterminated`, nil, 1},
+		{"(#0# #0=a) b", []string{"b"}, 1},
+		{"#0=#0# (#1=a #1=b) #2x #3#y ok", []string{"ok"}, 4},
+	}
+	for _, test := range tests {
+		data, errs := readAll(t, test.src)
+		if len(errs) != test.errors {
+			t.Errorf("%q: got errors %q, want %d", test.src, errs, test.errors)
+		}
+		if strings.Join(data, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%q: read %v, want %v", test.src, data, test.want)
+		}
+	}
+}
+
+func TestNumberErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"9223372036854775808", `number "9223372036854775808" is out of range`},
+		{"-9223372036854775809", `number "-9223372036854775809" is out of range`},
+		{"1e400", `number "1e400" is out of range`},
+		{"#x10000000000000000", `number "#x10000000000000000" is out of range`},
+		{"#xfg", `malformed number "#xfg"`},
+		{"#b102", `malformed number "#b102"`},
+	}
+	for _, test := range tests {
+		_, errs := readAll(t, test.src)
+		if len(errs) != 1 || errs[0] != test.want {
+			t.Errorf("%q: got errors %q, want %q", test.src, errs, test.want)
+		}
+	}
+}
+
+func TestEOFErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"(a (b", "unexpected EOF, expected a match for ("},
+		{"[a", "unexpected EOF, expected a match for ["},
+		{"#(1 2", "unexpected EOF, expected a match for #("},
+		{"{k", "unexpected EOF, expected a match for {"},
+		{"(a .", "unexpected EOF after ."},
+		{"'", "unexpected EOF after '"},
+	}
+	for _, test := range tests {
+		_, errs := readAll(t, test.src)
+		if len(errs) != 1 || errs[0] != test.want {
+			t.Errorf("%q: got errors %q, want %q", test.src, errs, test.want)
+		}
+	}
+}
+
+func list(items ...interface{}) interface{} {
+	return ListFrom(items, Null)
+}
+
+func TestWrite(t *testing.T) {
+	cyclic := list(1, 2).(*Pair)
+	cyclic.Cdr.(*Pair).Cdr = cyclic
+	shared := list("x")
+	vec := &Vector{Items: []interface{}{1, nil}}
+	vec.Items[1] = vec
+	tests := []struct {
+		v       interface{}
+		write   string
+		display string
+	}{
+		{list("a", Char('b'), 1.5), `("a" #\b 1.5)`, "(a b 1.5)"},
+		{&Pair{Car: 1, Cdr: 2}, "(1 . 2)", "(1 . 2)"},
+		{cyclic, "#0=(1 2 . #0#)", "#0=(1 2 . #0#)"},
+		{list(shared, shared), `(("x") ("x"))`, "((x) (x))"},
+		{vec, "#0=#(1 #0#)", "#0=#(1 #0#)"},
+	}
+	for _, test := range tests {
+		if got := Write(test.v); got != test.write {
+			t.Errorf("Write: got %s, want %s", got, test.write)
+		}
+		if got := Display(test.v); got != test.display {
+			t.Errorf("Display: got %s, want %s", got, test.display)
+		}
+	}
+}
+
+// whatever Write writes out, including cyclic data, can be read back in.
+func TestWriteRead(t *testing.T) {
+	cyclic := list(1, 2).(*Pair)
+	cyclic.Cdr.(*Pair).Cdr = cyclic
+	inner := list("x").(*Pair)
+	inner.Cdr = &Pair{Car: inner, Cdr: Null}
+	vec := &Vector{Items: []interface{}{1, nil}}
+	vec.Items[1] = list(vec, inner)
+	table := NewHashTable()
+	table.Set(Symbol("self"), table)
+	table.Set(list(1, 2), cyclic)
+
+	for _, v := range []interface{}{
+		list("a", Char('b'), 1.5, true, Symbol("c")),
+		cyclic,
+		list(inner, inner),
+		vec,
+		table,
+	} {
+		text := Write(v)
+		data, errs := readAll(t, text)
+		if len(errs) > 0 || len(data) != 1 {
+			t.Errorf("%s: read %v, with errors %v", text, data, errs)
+			continue
+		}
+		if data[0] != text {
+			t.Errorf("%s was read back as %s", text, data[0])
+		}
+	}
+
+	v, err := New(strings.NewReader("#0=(a #1=(b . #0#) . #1#)")).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := v.(*Pair)
+	b := a.Cdr.(*Pair).Car.(*Pair)
+	if b.Cdr != a || a.Cdr.(*Pair).Cdr != b {
+		t.Errorf("labels were read as %s", Write(v))
+	}
+}
+
+// printing deeply nested data is done without recursion, so it's run with a
+// small Go stack to make sure that it stays that way.
+func TestWriteDeep(t *testing.T) {
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	const depth = 1000000
+	var v interface{} = 1
+	for i := 0; i < depth; i++ {
+		v = &Pair{Car: v, Cdr: Null}
+	}
+	want := strings.Repeat("(", depth) + "1" + strings.Repeat(")", depth)
+	if got := Write(v); got != want {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestLimits(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		limits Limits
+		src    string
+		want   []string
+		errs   []string
+	}{
+		{Limits{MaxDepth: 3}, "(((a))) ((((b)))) c", []string{"(((a)))", "c"},
+			[]string{"( is nested more than 3 deep"}},
+		{Limits{MaxDepth: 2}, "''a '''b #(#(1)) {k {k {}}} #;(((x))) y", []string{"(quote (quote a))", "#(#(1))", "y"},
+			[]string{"' is nested more than 2 deep", "{ is nested more than 2 deep", "( is nested more than 2 deep"}},
+		{Limits{MaxDepth: 2}, "(((((a))))) [[[[b]]]] #0=#1=#2=c d", []string{"d"},
+			[]string{"( is nested more than 2 deep", "[ is nested more than 2 deep", "#2= is nested more than 2 deep"}},
+		{Limits{MaxToken: 10}, "abc " + long + " (a \"" + long + "\" b) ok", []string{"abc", "ok"},
+			[]string{"token is longer than 10 bytes", "token is longer than 10 bytes"}},
+		{Limits{MaxForm: 50}, "(a b c) (list " + strings.Repeat("1234 ", 1000) + ") ok", []string{"(a b c)", "ok"},
+			[]string{"datum is longer than 50 bytes"}},
+		{Limits{MaxForm: 50}, `"` + strings.Repeat("x", 1000) + `" ok`, []string{"ok"},
+			[]string{"datum is longer than 50 bytes"}},
+		{Limits{MaxForm: 50, MaxToken: 10}, "(a \"" + strings.Repeat("x", 1000) + "\" b) (" + long + ") ok", []string{"ok"},
+			[]string{"token is longer than 10 bytes", "token is longer than 10 bytes"}},
+	}
+	for _, test := range tests {
+		data, errs := readAllLimits(t, test.src, test.limits)
+		if strings.Join(data, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%+v: read %v, want %v", test.limits, data, test.want)
+		}
+		if strings.Join(errs, "; ") != strings.Join(test.errs, "; ") {
+			t.Errorf("%+v: got errors %q, want %q", test.limits, errs, test.errs)
+		}
+	}
+}
+
+func TestCheckMacroChar(t *testing.T) {
+	tests := []struct {
+		c    Char
+		want string
+	}{
+		{'@', ""},
+		{'λ', "can't be a macro character, since it can start a symbol or a number"},
+		{'a', "can't be a macro character, since it can start a symbol or a number"},
+		{'7', "can't be a macro character, since it can start a symbol or a number"},
+		{'+', "can't be a macro character, since it can start a symbol or a number"},
+		{'(', "can't be a macro character"},
+		{'#', "can't be a macro character"},
+		{' ', "can't be a macro character"},
+	}
+	for _, test := range tests {
+		err := CheckMacroChar(test.c)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%v: unexpected error %v", test.c, err)
+		case test.want != "" && (err == nil || !strings.HasSuffix(err.Error(), test.want)):
+			t.Errorf("%v: got error %v, want one ending in %q", test.c, err, test.want)
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	var saved *Port
+	r := New(strings.NewReader("@ab (x @(1 2) y) @"))
+	r.Macros = map[rune]Macro{
+		'@': func(c Char, p *Port) (interface{}, error) {
+			saved = p
+			peek, err := p.PeekChar()
+			if err == io.EOF {
+				return Symbol("eof"), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if peek == '(' {
+				v, err := p.Read()
+				return list(Symbol("at"), v), err
+			}
+			read, err := p.ReadChar()
+			return list(c, peek, read), err
+		},
+	}
+	want := []string{`(#\@ #\a #\a)`, "b", "(x (at (1 2)) y)", "eof"}
+	for _, w := range want {
+		v, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Write(v); got != w {
+			t.Errorf("read %s, want %s", got, w)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v at the end of the input, want EOF", err)
+	}
+	if _, err := saved.ReadChar(); err != errPortClosed {
+		t.Errorf("reading a port after its macro returned: got %v, want %v", err, errPortClosed)
+	}
+
+	// without a macro, @ is just part of a symbol.
+	if data, errs := readAll(t, "@ab"); len(errs) > 0 || len(data) != 1 || data[0] != "@ab" {
+		t.Errorf("read @ab without a macro as %v, with errors %v", data, errs)
+	}
+}
+
+// about 10MB of data, like a big data file.
+func bigInput() string {
+	const line = `(record 12345 -6.5e3 "some \"text\" here" #\a #(1 2 3) {k "v"} 'sym (nested (list . tail)))` + "\n"
+	return strings.Repeat(line, 10<<20/len(line))
+}
+
+// reads all of the data in the input.
+func BenchmarkNext(b *testing.B) {
+	src := bigInput()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := New(strings.NewReader(src))
+		for {
+			_, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// lexes all of the tokens in the input, with the lexer stepped by whoever
+// wants the tokens, the way that the reader does it.
+func BenchmarkTokens(b *testing.B) {
+	src := bigInput()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l := newLexer(strings.NewReader(src))
+		for l.token().t != eofToken {
+		}
+	}
+}
+
+// lexes all of the tokens in the input the way that the reader used to, with
+// the lexer running in its own goroutine and handing each token over on an
+// unbuffered channel.  This doesn't even parse the tokens, and it's still
+// slower than BenchmarkNext.
+func BenchmarkChannelTokens(b *testing.B) {
+	src := bigInput()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := make(chan token)
+		go func() {
+			l := newLexer(strings.NewReader(src))
+			for {
+				t := l.token()
+				c <- t
+				if t.t == eofToken {
+					close(c)
+					return
+				}
+			}
+		}()
+		for range c {
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"io"
+	"strings"
+)
+
+const (
+	prompt             = "skeam> "
+	continuationPrompt = "... "
+)
+
+// runs an interactive session, reading the input a line at a time.  Lines are
+// held on to until every form that was started on them has been finished, so
+// a form can be spread out over as many lines as it takes.  The prompt is
+// skeam> when we're waiting for a new form and ... when we're waiting for the
+// rest of one.  Unfinished forms are only held on to up to the reader's
+// MaxForm limit; past that, they're thrown away.  Each line is only looked
+// at once to see whether it finishes the forms before it, so the work that
+// goes into a form is bounded by MaxForm too.  The forms are read a few
+// lines at a time, but their positions are counted from the start of the
+// session.
+func (i interpreter) repl(env *environment) {
+	go i.send()
+	in := bufio.NewReader(i.in)
+	var buf strings.Builder
+	c := reader.NewCompleter()
+	start := 1 // the line of the session that buf starts on
+	for {
+		if buf.Len() == 0 {
+			i.prompt <- prompt
+		} else {
+			i.prompt <- continuationPrompt
+		}
+		max, left := reader.DefaultLimits.MaxForm, -1
+		if max > 0 {
+			left = max - buf.Len()
+		}
+		line, err := readLine(in, left)
+		if err == errLineTooLong {
+			i.errors <- fmt.Errorf("%v: form is longer than %d bytes", position{File: i.name, Line: start, Col: 1}, max)
+			start += strings.Count(buf.String(), "\n") + 1
+			buf.Reset()
+			c.Reset()
+			continue
+		}
+		buf.WriteString(line)
+		if !c.Add(line) && err == nil {
+			continue
+		}
+		// whatever's left over at the end of the input gets read anyway, so
+		// that an unfinished form is reported as an error.
+		if src := buf.String(); strings.TrimSpace(src) != "" {
+			i.read(strings.NewReader(src), start, env)
+		}
+		start += strings.Count(buf.String(), "\n")
+		buf.Reset()
+		c.Reset()
+		if err != nil {
+			if err != io.EOF {
+				i.errors <- err
+			}
+			i.prompt <- "\n"
+			i.done <- true
+			return
+		}
+	}
+}
+
+var errLineTooLong = errors.New("line is too long")
+
+// reads the rest of the current line, including its newline, like
+// ReadString('\n') does.  If the line is longer than max bytes, the rest of
+// it is skipped and errLineTooLong is returned instead.  A negative max means
+// there's no limit.
+func readLine(in *bufio.Reader, max int) (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := in.ReadSlice('\n')
+		if max < 0 || len(line)+len(chunk) <= max {
+			line = append(line, chunk...)
+		} else {
+			tooLong = true
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLong {
+			return "", errLineTooLong
+		}
+		return string(line), err
+	}
+}
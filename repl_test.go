@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/jordanorelli/skeam/reader"
+	"io"
+	"strings"
+	"testing"
+)
+
+// runs src through the repl, returning what it wrote out and the errors that
+// it reported, one per line.
+func runRepl(src string) (out string, errs []string) {
+	var stdout, stderr bytes.Buffer
+	i := newInterpreter("<stdin>", strings.NewReader(src), &stdout, &stderr)
+	i.repl(newEnvironment(universe))
+	return stdout.String(), strings.Split(strings.TrimSuffix(stderr.String(), "\n"), "\n")
+}
+
+// errors are reported at their positions in the whole session, not in the
+// few lines that the repl happened to be reading at the time.
+func TestReplPositions(t *testing.T) {
+	_, errs := runRepl("1\n(+ 1\n   2)\n\n   foo\n(car '())\n  [\n")
+	want := []string{"<stdin>:5:4:", "<stdin>:6:1:", "<stdin>:7:3:"}
+	if len(errs) != len(want) {
+		t.Fatalf("got errors %q, want %d", errs, len(want))
+	}
+	for n, w := range want {
+		if !strings.HasPrefix(errs[n], w) {
+			t.Errorf("got error %q, want one at %s", errs[n], w)
+		}
+	}
+}
+
+// lines are cut off at the limit that they're given, and the rest of a line
+// that's too long is skipped, even when it's longer than the buffer.
+func TestReadLine(t *testing.T) {
+	in := bufio.NewReaderSize(strings.NewReader("short\n"+strings.Repeat("x", 100)+"\nnext\nlast"), 16)
+	tests := []struct {
+		max  int
+		line string
+		err  error
+	}{
+		{10, "short\n", nil},
+		{50, "", errLineTooLong},
+		{-1, "next\n", nil},
+		{2, "", errLineTooLong},
+	}
+	for _, test := range tests {
+		line, err := readLine(in, test.max)
+		if line != test.line || err != test.err {
+			t.Errorf("readLine(%d) = %q, %v, want %q, %v", test.max, line, err, test.line, test.err)
+		}
+	}
+	if line, err := readLine(in, -1); line != "" || err != io.EOF {
+		t.Errorf("readLine at the end = %q, %v, want EOF", line, err)
+	}
+}
+
+// forms that are longer than MaxForm are thrown away as they're typed, and
+// the session carries on after them.
+func TestReplMaxForm(t *testing.T) {
+	defer func(limits reader.Limits) { reader.DefaultLimits = limits }(reader.DefaultLimits)
+	reader.DefaultLimits.MaxForm = 20
+
+	out, errs := runRepl("(list 1\n 2 3 4 5 6 7 8 9 10 11 12\n(+ 1 2)\n" + strings.Repeat("x", 100) + "\n(+ 2 2)\n")
+	want := []string{"<stdin>:1:1: form is longer than 20 bytes", "<stdin>:4:1: form is longer than 20 bytes"}
+	if strings.Join(errs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got errors %q, want %q", errs, want)
+	}
+	if !strings.Contains(out, "3\n") || !strings.Contains(out, "4\n") {
+		t.Errorf("got output %q, want 3 and 4 in it", out)
+	}
+}
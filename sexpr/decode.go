@@ -0,0 +1,331 @@
+package sexpr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// reads the s-expression in data and stores it in the value that v points
+// to.  data has to hold exactly one datum.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("sexpr: Unmarshal needs a non-nil pointer, received %v", reflect.TypeOf(v))
+	}
+	r := reader.New(bytes.NewReader(data))
+	r.Filename = "sexpr"
+	d, err := r.Next()
+	if err == io.EOF {
+		return errors.New("sexpr: no data to unmarshal")
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := r.Next(); err != io.EOF {
+		if err == nil {
+			return errors.New("sexpr: more than one datum to unmarshal")
+		}
+		return err
+	}
+	return decode(d, rv.Elem())
+}
+
+// describes the datum d for error messages.
+func describe(d interface{}) string {
+	switch d.(type) {
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case reader.Symbol:
+		return "symbol"
+	case reader.Char:
+		return "character"
+	case *reader.Pair, reader.EmptyList:
+		return "list"
+	case *reader.Vector:
+		return "vector"
+	case *reader.HashTable:
+		return "hash table"
+	}
+	return fmt.Sprintf("%T", d)
+}
+
+func mismatch(d interface{}, v reflect.Value) error {
+	return fmt.Errorf("sexpr: cannot unmarshal %s %s into Go value of type %v", describe(d), reader.Write(d), v.Type())
+}
+
+// stores the datum d in v, which has to be settable.
+func decode(d interface{}, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if d == reader.Null {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decode(d, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return mismatch(d, v)
+		}
+		n, err := native(d)
+		if err != nil {
+			return err
+		}
+		if n == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(n))
+		}
+		return nil
+	}
+
+	switch v.Type() {
+	case charType:
+		c, ok := d.(reader.Char)
+		if !ok {
+			return mismatch(d, v)
+		}
+		v.SetInt(int64(c))
+		return nil
+	case symbolType:
+		switch s := d.(type) {
+		case reader.Symbol:
+			v.SetString(string(s))
+		case string:
+			v.SetString(s)
+		case reader.EmptyList:
+			v.SetString("")
+		default:
+			return mismatch(d, v)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := d.(bool)
+		if !ok {
+			return mismatch(d, v)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := d.(int64)
+		if !ok || v.OverflowInt(n) {
+			return mismatch(d, v)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := d.(int64)
+		if !ok || n < 0 || v.OverflowUint(uint64(n)) {
+			return mismatch(d, v)
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		switch n := d.(type) {
+		case int64:
+			v.SetFloat(float64(n))
+		case float64:
+			v.SetFloat(n)
+		default:
+			return mismatch(d, v)
+		}
+	case reflect.String:
+		switch s := d.(type) {
+		case string:
+			v.SetString(s)
+		case reader.Symbol:
+			v.SetString(string(s))
+		default:
+			return mismatch(d, v)
+		}
+	case reflect.Slice:
+		if d == reader.Null {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		items, ok := sequence(d)
+		if !ok {
+			return mismatch(d, v)
+		}
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decode(item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		items, ok := sequence(d)
+		if !ok || len(items) > v.Len() {
+			return mismatch(d, v)
+		}
+		v.Set(reflect.Zero(v.Type()))
+		for i, item := range items {
+			if err := decode(item, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if d == reader.Null {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		t, ok := d.(*reader.HashTable)
+		if !ok {
+			return mismatch(d, v)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		return decodeMap(t, v)
+	case reflect.Struct:
+		t, ok := d.(*reader.HashTable)
+		if !ok {
+			return mismatch(d, v)
+		}
+		return decodeStruct(t, v)
+	default:
+		return fmt.Errorf("sexpr: unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+// the items of a list or vector.  The second return value is false if d is
+// neither, or if it's a dotted list.
+func sequence(d interface{}) ([]interface{}, bool) {
+	if vec, ok := d.(*reader.Vector); ok {
+		return vec.Items, true
+	}
+	var items []interface{}
+	for {
+		switch t := d.(type) {
+		case reader.EmptyList:
+			return items, true
+		case *reader.Pair:
+			items = append(items, t.Car)
+			d = t.Cdr
+		default:
+			return nil, false
+		}
+	}
+}
+
+func decodeMap(t *reader.HashTable, v reflect.Value) error {
+	var err error
+	t.Each(func(key, value interface{}) {
+		if err != nil {
+			return
+		}
+		k := reflect.New(v.Type().Key()).Elem()
+		if err = decode(key, k); err != nil {
+			return
+		}
+		e := reflect.New(v.Type().Elem()).Elem()
+		if err = decode(value, e); err != nil {
+			return
+		}
+		v.SetMapIndex(k, e)
+	})
+	return err
+}
+
+func decodeStruct(t *reader.HashTable, v reflect.Value) error {
+	fs := fields(v.Type())
+	var err error
+	t.Each(func(key, value interface{}) {
+		if err != nil {
+			return
+		}
+		var name string
+		switch k := key.(type) {
+		case reader.Symbol:
+			name = string(k)
+		case string:
+			name = k
+		default:
+			err = fmt.Errorf("sexpr: %s can't name a field of %v", reader.Write(key), v.Type())
+			return
+		}
+		if f, ok := findField(fs, name); ok {
+			err = decode(value, v.Field(f.index))
+		}
+	})
+	return err
+}
+
+// finds the field called name, preferring an exact match, but otherwise
+// ignoring case.
+func findField(fs []field, name string) (field, bool) {
+	for _, f := range fs {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fs {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// converts the datum d into the Go value that Unmarshal stores in an empty
+// interface.
+func native(d interface{}) (interface{}, error) {
+	switch t := d.(type) {
+	case reader.EmptyList:
+		return nil, nil
+	case *reader.Pair, *reader.Vector:
+		items, ok := sequence(t)
+		if !ok {
+			return nil, fmt.Errorf("sexpr: cannot unmarshal dotted list %s", reader.Write(d))
+		}
+		s := make([]interface{}, len(items))
+		for i, item := range items {
+			n, err := native(item)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = n
+		}
+		return s, nil
+	case *reader.HashTable:
+		m := make(map[interface{}]interface{}, t.Len())
+		var err error
+		t.Each(func(key, value interface{}) {
+			if err != nil {
+				return
+			}
+			var k, v interface{}
+			if k, err = native(key); err != nil {
+				return
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				err = fmt.Errorf("sexpr: cannot use %s as a map key", reader.Write(key))
+				return
+			}
+			if v, err = native(value); err != nil {
+				return
+			}
+			m[k] = v
+		})
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return d, nil
+}
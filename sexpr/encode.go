@@ -0,0 +1,270 @@
+// Package sexpr converts Go values to and from s-expressions, the way that
+// encoding/json converts them to and from JSON.  The syntax is exactly what
+// skeam reads, since values are read with the reader package and written
+// with its printer.
+//
+// Go values are written as follows:
+//
+//	bool                        #t and #f
+//	integers                    123
+//	floats                      1.5, but not infinities or NaN
+//	string                      "abc"
+//	reader.Symbol               abc
+//	reader.Char                 #\a
+//	slices and arrays           lists, like (1 2 3)
+//	maps                        hash tables, like {"a" 1 "b" 2}
+//	structs                     hash tables keyed by symbols, like {name "x" port 80}
+//	nil pointers, slices, etc.  ()
+//
+// The empty symbol is written as (), since there's no other way to write it.
+// Pointers and interfaces are written as the values they point to.  Struct
+// fields are keyed by their names unless their sexp tags say otherwise:
+//
+//	Name string `sexp:"name"`            // written as name
+//	Port int    `sexp:"port,omitempty"`  // left out if it's 0
+//	Temp int    `sexp:"-"`               // never written
+//
+// Unmarshal reverses all of that.  It also reads vectors into slices and
+// arrays, and reads symbols into strings.  Struct fields are matched to keys
+// the way encoding/json matches them, preferring an exact match but
+// otherwise ignoring case, and keys that match no field are ignored.  Data
+// unmarshaled into an empty interface are stored as:
+//
+//	bool, int64, float64, string, reader.Symbol, reader.Char   as they are
+//	lists and vectors                                          []interface{}
+//	hash tables                                                map[interface{}]interface{}
+//	()                                                         nil
+package sexpr
+
+import (
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	symbolType = reflect.TypeOf(reader.Symbol(""))
+	charType   = reflect.TypeOf(reader.Char(0))
+)
+
+// returns the s-expression for v.
+func Marshal(v interface{}) ([]byte, error) {
+	e := encoder{visiting: make(map[visit]bool)}
+	d, err := e.encode(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(reader.Write(d)), nil
+}
+
+// type encoder turns Go values into the data that the reader would read for
+// them.  It keeps track of the pointers, slices and maps that it's in the
+// middle of encoding, so that a value that contains itself is an error
+// instead of a stack overflow.
+type encoder struct {
+	visiting map[visit]bool
+}
+
+// type visit identifies a pointer, slice or map that's being encoded.  Like
+// encoding/json, slices are told apart by their lengths as well as where they
+// start, since a slice can hold a shorter slice of the same array without
+// containing itself.
+type visit struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// encodes v, which is a pointer, slice or map, with encode, unless it's
+// already being encoded further up.
+func (e encoder) encodeOnce(v reflect.Value, encode func(reflect.Value) (interface{}, error)) (interface{}, error) {
+	key := visit{v.Pointer(), 0, v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if e.visiting[key] {
+		return nil, fmt.Errorf("sexpr: %v contains itself", v.Type())
+	}
+	e.visiting[key] = true
+	defer delete(e.visiting, key)
+	return encode(v)
+}
+
+func (e encoder) encode(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return reader.Null, nil
+	}
+	switch v.Type() {
+	case symbolType:
+		if v.String() == "" {
+			return reader.Null, nil
+		}
+		return symbol(v.String())
+	case charType:
+		return reader.Char(v.Int()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("sexpr: %d is too big to be an integer", n)
+		}
+		return int64(n), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("sexpr: %v can't be written, since it isn't a finite number", f)
+		}
+		return f, nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return reader.Null, nil
+		}
+		return e.encodeOnce(v, e.encodeList)
+	case reflect.Array:
+		return e.encodeList(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return reader.Null, nil
+		}
+		return e.encodeOnce(v, e.encodeMap)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return reader.Null, nil
+		}
+		return e.encodeOnce(v, func(v reflect.Value) (interface{}, error) {
+			return e.encode(v.Elem())
+		})
+	case reflect.Interface:
+		return e.encode(v.Elem())
+	}
+	return nil, fmt.Errorf("sexpr: unsupported type %v", v.Type())
+}
+
+// checks that the symbol s reads back as itself.  Symbols with spaces or
+// parens in them can't be written, since the reader has no way to quote
+// them.
+func symbol(s string) (interface{}, error) {
+	d, err := reader.New(strings.NewReader(s)).Next()
+	if err != nil || d != reader.Symbol(s) {
+		return nil, fmt.Errorf("sexpr: %q can't be written as a symbol", s)
+	}
+	return d, nil
+}
+
+func (e encoder) encodeList(v reflect.Value) (interface{}, error) {
+	var list interface{} = reader.Null
+	for i := v.Len() - 1; i >= 0; i-- {
+		item, err := e.encode(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		list = &reader.Pair{Car: item, Cdr: list}
+	}
+	return list, nil
+}
+
+// encodes a map as a hash table.  The keys are sorted by how they're written,
+// so that a map always comes out the same way.
+func (e encoder) encodeMap(v reflect.Value) (interface{}, error) {
+	type entry struct {
+		key, value interface{}
+		text       string
+	}
+	entries := make([]entry, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		key, err := e.encode(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.encode(v.MapIndex(k))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key, value, reader.Write(key)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].text < entries[j].text })
+
+	t := reader.NewHashTable()
+	for _, entry := range entries {
+		t.Set(entry.key, entry.value)
+	}
+	return t, nil
+}
+
+func (e encoder) encodeStruct(v reflect.Value) (interface{}, error) {
+	t := reader.NewHashTable()
+	for _, f := range fields(v.Type()) {
+		fv := v.Field(f.index)
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		value, err := e.encode(fv)
+		if err != nil {
+			return nil, err
+		}
+		key, err := symbol(f.name)
+		if err != nil {
+			return nil, err
+		}
+		t.Set(key, value)
+	}
+	return t, nil
+}
+
+// whether v is the zero value of its type, or an empty slice or map, which
+// is what omitempty leaves out.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// type field describes how a struct field is written.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// the fields of the struct type t that get written, in the order that
+// they're declared.  Unexported fields and fields tagged "-" are left out.
+func fields(t reflect.Type) []field {
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("sexp")
+		if tag == "-" {
+			continue
+		}
+		f := field{name: sf.Name, index: i}
+		opts := strings.Split(tag, ",")
+		if opts[0] != "" {
+			f.name = opts[0]
+		}
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
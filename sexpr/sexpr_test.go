@@ -0,0 +1,220 @@
+package sexpr
+
+import (
+	"github.com/jordanorelli/skeam/reader"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type server struct {
+	Name    string            `sexp:"name"`
+	Port    int               `sexp:"port,omitempty"`
+	Temp    int               `sexp:"-"`
+	Tags    []string          `sexp:"tags,omitempty"`
+	Owner   *string           `sexp:"owner"`
+	Env     map[string]string `sexp:"env,omitempty"`
+	Enabled bool
+	secret  int
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "()"},
+		{true, "#t"},
+		{-12, "-12"},
+		{uint8(200), "200"},
+		{1.5, "1.5"},
+		{"a \"b\"", `"a \"b\""`},
+		{reader.Symbol("abc"), "abc"},
+		{reader.Symbol(""), "()"},
+		{reader.Char('a'), `#\a`},
+		{[]int{1, 2, 3}, "(1 2 3)"},
+		{[2]bool{true, false}, "(#t #f)"},
+		{[]int(nil), "()"},
+		{map[string]int{"b": 2, "a": 1}, `{"a" 1 "b" 2}`},
+		{str("x"), `"x"`},
+		{(*int)(nil), "()"},
+		{[]interface{}{1, "a", nil}, `(1 "a" ())`},
+		{server{Name: "web", Temp: 5, secret: 1}, `{name "web" owner () Enabled #f}`},
+		{&server{Name: "db", Port: 5432, Tags: []string{"a"}, Owner: str("me"), Env: map[string]string{"k": "v"}, Enabled: true},
+			`{name "db" port 5432 tags ("a") owner "me" env {"k" "v"} Enabled #t}`},
+	}
+	for _, test := range tests {
+		b, err := Marshal(test.v)
+		if err != nil {
+			t.Errorf("Marshal(%#v): %v", test.v, err)
+			continue
+		}
+		if string(b) != test.want {
+			t.Errorf("Marshal(%#v) = %s, want %s", test.v, b, test.want)
+		}
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	loop := &node{}
+	loop.Next = loop
+	s := []interface{}{nil}
+	s[0] = s
+	m := map[string]interface{}{}
+	m["m"] = m
+
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{loop, "contains itself"},
+		{s, "contains itself"},
+		{m, "contains itself"},
+		{math.Inf(1), "isn't a finite number"},
+		{[]float64{math.NaN()}, "isn't a finite number"},
+		{uint64(math.MaxUint64), "too big"},
+		{reader.Symbol("a b"), "can't be written as a symbol"},
+		{make(chan int), "unsupported type"},
+	}
+	for _, test := range tests {
+		_, err := Marshal(test.v)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Marshal(%T): got error %v, want one containing %q", test.v, err, test.want)
+		}
+	}
+
+	// the same slice can show up more than once without containing itself.
+	shared := []int{1}
+	if b, err := Marshal([][]int{shared, shared}); err != nil || string(b) != "((1) (1))" {
+		t.Errorf("Marshal of a shared slice: got %s, %v", b, err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var srv server
+	err := Unmarshal([]byte(`{NAME "web" Port 80 tags #(a "b") owner "me" enabled #t Temp 3 unknown 1}`), &srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := server{Name: "web", Port: 80, Tags: []string{"a", "b"}, Owner: str("me"), Enabled: true}
+	if !reflect.DeepEqual(srv, want) {
+		t.Errorf("got %+v, want %+v", srv, want)
+	}
+
+	// an exact match wins over one that only matches when ignoring case.
+	var exact struct {
+		Foo int
+		FOO int
+	}
+	if err := Unmarshal([]byte("{FOO 1 foo 2}"), &exact); err != nil {
+		t.Fatal(err)
+	}
+	if exact.FOO != 1 || exact.Foo != 2 {
+		t.Errorf("got %+v, want {Foo:2 FOO:1}", exact)
+	}
+
+	var got interface{}
+	if err := Unmarshal([]byte(`(1 2.5 "s" sym #\c #t () #(x) {"k" (v)})`), &got); err != nil {
+		t.Fatal(err)
+	}
+	wantAny := []interface{}{int64(1), 2.5, "s", reader.Symbol("sym"), reader.Char('c'), true, nil,
+		[]interface{}{reader.Symbol("x")},
+		map[interface{}]interface{}{"k": []interface{}{reader.Symbol("v")}}}
+	if !reflect.DeepEqual(got, wantAny) {
+		t.Errorf("got %#v, want %#v", got, wantAny)
+	}
+
+	var p *int
+	if err := Unmarshal([]byte("7"), &p); err != nil || p == nil || *p != 7 {
+		t.Errorf("pointer: got %v, %v", p, err)
+	}
+	if err := Unmarshal([]byte("()"), &p); err != nil || p != nil {
+		t.Errorf("nil pointer: got %v, %v", p, err)
+	}
+
+	var arr [3]int
+	if err := Unmarshal([]byte("(1 2)"), &arr); err != nil || arr != [3]int{1, 2, 0} {
+		t.Errorf("array: got %v, %v", arr, err)
+	}
+
+	var m map[string][]float64
+	if err := Unmarshal([]byte(`{"a" (1 2.5) b ()}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string][]float64{"a": {1, 2.5}, "b": nil}) {
+		t.Errorf("map: got %v", m)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var n int8
+	var s []int
+	var i interface{}
+	var st struct{ A int }
+	var stringer interface{ String() string }
+	tests := []struct {
+		src  string
+		v    interface{}
+		want string
+	}{
+		{"1", n, "non-nil pointer"},
+		{"", &n, "no data"},
+		{"1 2", &n, "more than one datum"},
+		{"(1", &n, "sexpr:1:1"},
+		{"300", &n, "cannot unmarshal integer 300 into Go value of type int8"},
+		{`"x"`, &n, "cannot unmarshal string"},
+		{"(1 . 2)", &s, "cannot unmarshal list (1 . 2)"},
+		{"(1 . 2)", &i, "dotted list"},
+		{"{(a) 1}", &i, "as a map key"},
+		{"{1 2}", &st, "can't name a field"},
+		{"1", &stringer, "cannot unmarshal integer"},
+	}
+	for _, test := range tests {
+		err := Unmarshal([]byte(test.src), test.v)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Unmarshal(%q, %T): got error %v, want one containing %q", test.src, test.v, err, test.want)
+		}
+	}
+}
+
+// input that's nested too deeply is an error, rather than running the
+// process out of stack.
+func TestUnmarshalDeep(t *testing.T) {
+	var v interface{}
+	err := Unmarshal([]byte(strings.Repeat("(", 10<<20)), &v)
+	if err == nil || !strings.Contains(err.Error(), "nested more than") {
+		t.Errorf("got error %v, want one about nesting", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := server{Name: "x", Port: 1, Tags: []string{"a b", "c"}, Owner: str("o"), Env: map[string]string{"k": ""}}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out server
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("%s came back as %+v", b, out)
+	}
+
+	floats := []float64{0, -1.5, 1e300, math.SmallestNonzeroFloat64}
+	if b, err = Marshal(floats); err != nil {
+		t.Fatal(err)
+	}
+	var back []float64
+	if err := Unmarshal(b, &back); err != nil || !reflect.DeepEqual(floats, back) {
+		t.Errorf("%s came back as %v, %v", b, back, err)
+	}
+}
@@ -1,92 +1,146 @@
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
-	"io"
+	"github.com/jordanorelli/skeam/reader"
 	"os"
 	"reflect"
-	"strconv"
-	"strings"
 )
 
 var DEBUG bool
 
-type sexp struct {
-	items    []interface{}
-	quotelvl int
-}
+// the types of the data that come out of the reader.  They're aliased here
+// since they're also the types that the interpreter works with.
+type (
+	symbol    = reader.Symbol
+	pair      = reader.Pair
+	emptyList = reader.EmptyList
+	char      = reader.Char
+	vector    = reader.Vector
+	hashTable = reader.HashTable
+	port      = reader.Port
+	position  = reader.Pos
+)
 
-func (s *sexp) eval(env *environment) (interface{}, error) {
-	debugPrint("eval sexp")
-	if s.len() == 0 {
-		return nil, errors.New("illegal evaluation of empty sexp ()")
-	}
+// the empty list, ()
+var null = reader.Null
 
-	if s.quotelvl > 0 {
-		return s, nil
-	}
+// type unspecifiedValue is the type of the value of forms like define and set!,
+// which are evaluated for their effects rather than for a value.  It has no
+// other values.
+type unspecifiedValue struct{}
 
-	// eval the first item
-	v, err := eval(s.items[0], env)
+func (unspecifiedValue) String() string {
+	return "#<unspecified>"
+}
+
+// the value of forms that don't have a useful value.  The REPL doesn't print
+// it.
+var unspecified = unspecifiedValue{}
+
+// evaluates the call p, which starts by evaluating the thing being called.
+// Symbols are looked up right away, since that's by far the most common case
+// and it saves a trip through the machine.
+func callPair(p *pair, env *environment) (interface{}, error) {
+	args, err := listItems(p.Cdr)
 	if err != nil {
 		return nil, err
 	}
 
+	if s, ok := p.Car.(symbol); ok {
+		v, err := env.get(s)
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := v.(*syntaxRules); ok {
+			x, err := m.expandCached(p)
+			if err != nil {
+				return nil, err
+			}
+			return tailCall{x, env}, nil
+		}
+		return callValue(v, env, args)
+	}
+	return evalThen{p.Car, env, func(v interface{}) (interface{}, error) {
+		return callValue(v, env, args)
+	}}, nil
+}
+
+// calls v, which should be the value of the head of a form, with the
+// unevaluated args of the form.
+func callValue(v interface{}, env *environment, args []interface{}) (interface{}, error) {
 	c, ok := v.(callable)
 	if !ok {
 		return nil, fmt.Errorf(`expected special form or builtin procedure, received %v`, reflect.TypeOf(v))
 	}
-	if len(s.items) > 1 {
-		return c.call(env, s.items[1:])
-	}
-	return c.call(env, nil)
+	return c.call(env, args)
 }
 
+// type callable is anything that can be called.  A callable receives its
+// arguments unevaluated, along with the environment of the call, and returns
+// either a value or an instruction for the machine.
 type callable interface {
 	call(*environment, []interface{}) (interface{}, error)
 }
 
-func newSexp() *sexp {
-	return &sexp{
-		items:    make([]interface{}, 0, 8),
-		quotelvl: 0,
-	}
+// type procedure is a callable that evaluates all of its arguments before
+// doing anything with them, which means that it can also be applied directly
+// to a list of values that have already been evaluated.  invoke does that on
+// the machine that's already running, returning an instruction for it, while
+// apply is for Go code, and runs the procedure to completion.
+type procedure interface {
+	callable
+	invoke([]interface{}) (interface{}, error)
+	apply([]interface{}) (interface{}, error)
 }
 
-func (s sexp) String() string {
-	parts := make([]string, len(s.items))
-	for i, _ := range s.items {
-		parts[i] = fmt.Sprint(s.items[i])
+// collects the items of a proper list into a slice.  Improper lists and
+// non-lists are an error.
+func listItems(v interface{}) ([]interface{}, error) {
+	var items []interface{}
+	for v != null {
+		p, ok := v.(*pair)
+		if !ok {
+			return nil, fmt.Errorf("expected proper list, found improper tail %v", repr(v))
+		}
+		items = append(items, p.Car)
+		v = p.Cdr
 	}
-	return "(" + strings.Join(parts, " ") + ")"
-}
-
-func (s *sexp) append(item interface{}) {
-	s.items = append(s.items, item)
+	return items, nil
 }
 
-func (s sexp) len() int {
-	return len(s.items)
+// checks whether v is a proper list, i.e., a chain of pairs that ends in
+// null.  Circular lists aren't proper lists, so we walk the list at two speeds
+// to catch them.
+func isList(v interface{}) bool {
+	slow := v
+	for {
+		for i := 0; i < 2; i++ {
+			if v == null {
+				return true
+			}
+			p, ok := v.(*pair)
+			if !ok {
+				return false
+			}
+			v = p.Cdr
+		}
+		slow = slow.(*pair).Cdr
+		if slow == v {
+			return false
+		}
+	}
 }
 
-type symbol string
-
-func (s symbol) eval(env *environment) (interface{}, error) {
-	debugPrint("eval symbol")
-	v, err := env.get(s)
-	if err != nil {
-		return nil, err
-	}
-	return eval(v, env)
+// formats a value for printing, in a form that can be read back in.
+func repr(v interface{}) string {
+	return reader.Write(v)
 }
 
 var universe = &environment{map[symbol]interface{}{
 	// predefined values
-	"#t":   true,
-	"#f":   false,
-	"null": nil,
+	"null": null,
 
 	// builtin functions
 	symbol(add.name):      add,
@@ -98,107 +152,129 @@ var universe = &environment{map[symbol]interface{}{
 	symbol(lt.name):       lt,
 	symbol(lte.name):      lte,
 	symbol(equals.name):   equals,
+	symbol(isEqual.name):  isEqual,
+	symbol(isEqv.name):    isEqv,
 	symbol(and.name):      and,
 	symbol(or.name):       or,
 	symbol(cons.name):     cons,
 	symbol(car.name):      car,
 	symbol(cdr.name):      cdr,
+	symbol(setCar.name):   setCar,
+	symbol(setCdr.name):   setCdr,
+	symbol(ispair.name):   ispair,
 	symbol(length.name):   length,
 	symbol(lst.name):      lst,
 	symbol(islist.name):   islist,
 	symbol(not.name):      not,
 	symbol(isnull.name):   isnull,
 	symbol(issymbol.name): issymbol,
-	// "=":       builtin(equal),
-	// "equal?":  builtin(equal),
-	// "eq?"
-	// "append"
 
-	// special forms
-	symbol(begin.name):    begin,
-	symbol(define.name):   define,
-	symbol(_if.name):      _if,
-	symbol(mklambda.name): mklambda,
-	symbol(quote.name):    quote,
-	symbol(set.name):      set,
-}, nil}
+	// characters
+	symbol(ischar.name):           ischar,
+	symbol(charToInteger.name):    charToInteger,
+	symbol(integerToChar.name):    integerToChar,
+	symbol(charUpcase.name):       charUpcase,
+	symbol(charDowncase.name):     charDowncase,
+	symbol(isCharAlphabetic.name): isCharAlphabetic,
+	symbol(isCharNumeric.name):    isCharNumeric,
+	symbol(charEquals.name):       charEquals,
+	symbol(charLess.name):         charLess,
 
-func init() {
-	universe.set(symbol(names.name), names)
-}
+	// vectors
+	symbol(makeVector.name):    makeVector,
+	symbol(mkvector.name):      mkvector,
+	symbol(isvector.name):      isvector,
+	symbol(vectorRef.name):     vectorRef,
+	symbol(vectorSet.name):     vectorSet,
+	symbol(vectorLength.name):  vectorLength,
+	symbol(vectorToList.name):  vectorToList,
+	symbol(listToVector.name):  listToVector,
+	symbol(vectorFill.name):    vectorFill,
+	symbol(vectorMap.name):     vectorMap,
+	symbol(vectorForEach.name): vectorForEach,
 
-// parses the string lexeme into a value that can be eval'd
-func atom(t token) (interface{}, error) {
-	switch t.t {
-	case integerToken:
-		val, err := strconv.ParseInt(t.lexeme, 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		return val, nil
+	// hash tables
+	symbol(makeHashTable.name):       makeHashTable,
+	symbol(isHashTable.name):         isHashTable,
+	symbol(hashTableSize.name):       hashTableSize,
+	symbol(hashTableGet.name):        hashTableGet,
+	symbol(hashTableGetDefault.name): hashTableGetDefault,
+	symbol(hashTableSet.name):        hashTableSet,
+	symbol(hashTableDelete.name):     hashTableDelete,
+	symbol(hashTableContains.name):   hashTableContains,
+	symbol(hashTableKeys.name):       hashTableKeys,
+	symbol(hashTableValues.name):     hashTableValues,
+	symbol(hashTableToList.name):     hashTableToList,
+	symbol(hashTableWalk.name):       hashTableWalk,
+	symbol(hashTableUpdate.name):     hashTableUpdate,
 
-	case floatToken:
-		val, err := strconv.ParseFloat(t.lexeme, 64)
-		if err != nil {
-			return nil, err
-		}
-		return val, nil
+	// continuations
+	symbol(callCC.name):      callCC,
+	"call/cc":                callCC,
+	symbol(dynamicWind.name): dynamicWind,
 
-	case stringToken:
-		return t.lexeme, nil
+	// reader macros
+	symbol(defineReaderMacro.name): defineReaderMacro,
+	symbol(readChar.name):          readChar,
+	symbol(peekChar.name):          peekChar,
+	symbol(read.name):              read,
+	symbol(isEOFObject.name):       isEOFObject,
 
-	case symbolToken:
-		return symbol(t.lexeme), nil
-	}
+	// output
+	symbol(write.name):   write,
+	symbol(display.name): display,
+	symbol(newline.name): newline,
 
-	return nil, fmt.Errorf("unable to atomize token: %v", t)
-}
+	// "=":       builtin(equal),
+	// "eq?"
+	// "append"
 
-// reads in tokens on the channel until a matching close paren is found.
-func (s *sexp) readIn(c chan token) error {
-	for t := range c {
-		switch t.t {
-		case closeParenToken:
-			return nil
-		case openParenToken:
-			child := newSexp()
-			if err := child.readIn(c); err != nil {
-				return err
-			}
-			s.append(child)
-		default:
-			v, err := atom(t)
-			if err != nil {
-				return err
-			}
-			s.append(v)
-		}
-	}
-	return errors.New("unexpected EOF in sexp.readIn")
+	// special forms
+	symbol(begin.name):        begin,
+	symbol(_case.name):        _case,
+	symbol(cond.name):         cond,
+	symbol(do.name):           do,
+	symbol(mkcaseLambda.name): mkcaseLambda,
+	symbol(define.name):       define,
+	symbol(_if.name):          _if,
+	symbol(let.name):          let,
+	symbol(letStar.name):      letStar,
+	symbol(letrec.name):       letrec,
+	symbol(letrecStar.name):   letrecStar,
+	symbol(mklambda.name):     mklambda,
+	symbol(quote.name):        quote,
+	symbol(quasiquote.name):   quasiquote,
+	symbol(set.name):          set,
+	symbol(unless.name):       unless,
+	symbol(when.name):         when,
+
+	// macros
+	symbol(mksyntaxRules.name): mksyntaxRules,
+	symbol(defineSyntax.name):  defineSyntax,
+	symbol(letSyntax.name):     letSyntax,
+	symbol(letrecSyntax.name):  letrecSyntax,
+	symbol(macroexpand.name):   macroexpand,
+	symbol(macroexpand1.name):  macroexpand1,
+}, nil, nil}
+
+func init() {
+	universe.set(symbol(names.name), names)
 }
 
-// parses one value that can be evaled from the channel
-func parse(c chan token) (interface{}, error) {
-	for t := range c {
-		switch t.t {
-		case closeParenToken:
-			return nil, errors.New("unexpected close paren in read")
-		case openParenToken:
-			s := newSexp()
-			if err := s.readIn(c); err != nil {
-				return nil, err
-			}
-			return s, nil
-		default:
-			return atom(t)
-		}
+func debugPrint(s string) {
+	if DEBUG {
+		fmt.Println("#", s)
 	}
-	return nil, io.EOF
 }
 
 func main() {
 	flag.BoolVar(&DEBUG, "debug", false, "puts the interpreter in debug mode")
 	flag.Parse()
+	reader.DefaultLimits = reader.Limits{
+		MaxDepth: *maxDepth,
+		MaxToken: *maxToken,
+		MaxForm:  *maxForm,
+	}
 	if DEBUG {
 		fmt.Println(universe)
 	}
@@ -213,6 +289,10 @@ func main() {
 		return
 	}
 
-	i := newInterpreter(os.Stdin, os.Stdout, os.Stderr)
-	i.run(universe)
+	i := newInterpreter("<stdin>", os.Stdin, os.Stdout, os.Stderr)
+	if isTerminal(os.Stdin) {
+		i.repl(universe)
+	} else {
+		i.run(universe)
+	}
 }
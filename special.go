@@ -3,7 +3,9 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/jordanorelli/skeam/reader"
 	"reflect"
+	"strings"
 )
 
 // type special is a callable outside of the normal execution workflow.  That
@@ -17,7 +19,11 @@ type special struct {
 	fn       func(*environment, []interface{}) (interface{}, error)
 }
 
-func (s special) checkArity(n int) error {
+func (s *special) String() string {
+	return "#<syntax " + s.name + ">"
+}
+
+func (s *special) checkArity(n int) error {
 	if n == s.arity {
 		return nil
 	}
@@ -34,7 +40,7 @@ func (s special) checkArity(n int) error {
 	}
 }
 
-func (s special) call(env *environment, rawArgs []interface{}) (interface{}, error) {
+func (s *special) call(env *environment, rawArgs []interface{}) (interface{}, error) {
 	if err := s.checkArity(len(rawArgs)); err != nil {
 		return nil, err
 	}
@@ -48,16 +54,40 @@ type arityError struct {
 	received int
 	name     string
 	variadic bool
+
+	// how many more arguments than expected are accepted, for procedures
+	// with #!optional parameters
+	optional int
+
+	// the arities of each of the clauses of a case-lambda, any one of which
+	// would have been accepted
+	clauses []arityError
 }
 
 func (n arityError) Error() string {
-	if n.variadic {
-		return fmt.Sprintf(`received %d arguments in *%v*, expected %d (or more)`,
-			n.received, n.name, n.expected)
-	}
+	return fmt.Sprintf(`received %d arguments in *%v*, expected %s`,
+		n.received, n.name, n.expectation())
+}
 
-	return fmt.Sprintf(`received %d arguments in *%v*, expected %d`,
-		n.received, n.name, n.expected)
+// describes how many arguments were expected.
+func (n arityError) expectation() string {
+	switch {
+	case len(n.clauses) > 0:
+		s := make([]string, len(n.clauses))
+		for i, c := range n.clauses {
+			s[i] = c.expectation()
+		}
+		last := len(s) - 1
+		if last == 0 {
+			return s[0]
+		}
+		return strings.Join(s[:last], ", ") + " or " + s[last]
+	case n.variadic:
+		return fmt.Sprintf("%d (or more)", n.expected)
+	case n.optional > 0:
+		return fmt.Sprintf("%d to %d", n.expected, n.expected+n.optional)
+	}
+	return fmt.Sprintf("%d", n.expected)
 }
 
 // helper function to check the arity of incoming arguments for a function.
@@ -67,10 +97,10 @@ func checkArity(arity int, args []interface{}, name string) error {
 		if arity == 0 {
 			return nil
 		}
-		return arityError{arity, 0, name, false}
+		return arityError{expected: arity, received: 0, name: name}
 	}
 	if len(args) != arity {
-		return arityError{arity, len(args), name, false}
+		return arityError{expected: arity, received: len(args), name: name}
 	}
 	return nil
 }
@@ -79,26 +109,71 @@ func checkArity(arity int, args []interface{}, name string) error {
 //
 //  (define x 5)
 //
-// would create the symbol "x" and set its value to 5.
-var define = special{
-	name:  "define",
-	arity: 2,
+// would create the symbol "x" and set its value to 5.  There's also a
+// shorthand for defining procedures:
+//
+//  (define (f x) (* x x))
+//
+// is short for (define f (lambda (x) (* x x))), and it can be curried, so
+//
+//  (define ((adder n) x) (+ n x))
+//
+// is short for (define (adder n) (lambda (x) (+ n x))).
+var define = &special{
+	name:     "define",
+	arity:    2,
+	variadic: true,
 	fn: func(env *environment, args []interface{}) (interface{}, error) {
-		s, ok := args[0].(symbol)
-		if !ok {
-			return nil, fmt.Errorf(`first argument to *define* must be symbol, received %v`, reflect.TypeOf(args[0]))
-		}
-
-		v, err := eval(args[1], env)
+		s, expr, err := definition(args)
 		if err != nil {
 			return nil, err
 		}
-		env.set(s, v)
 
-		return nil, nil
+		return evalThen{expr, env, func(v interface{}) (interface{}, error) {
+			env.set(s, named(v, s))
+			return unspecified, nil
+		}}, nil
 	},
 }
 
+// takes apart the arguments of a define, returning the symbol being defined
+// and the expression for its value.  The procedure shorthand is expanded into
+// a lambda expression, which calls mklambda directly rather than looking up
+// the symbol lambda, so that it means the same thing wherever it's used.
+func definition(args []interface{}) (symbol, interface{}, error) {
+	target, body := args[0], args[1:]
+	for {
+		p, ok := target.(*pair)
+		if !ok {
+			break
+		}
+		body = []interface{}{&pair{Car: mklambda, Cdr: &pair{Car: p.Cdr, Cdr: reader.ListFrom(body, null)}}}
+		target = p.Car
+	}
+
+	s, ok := target.(symbol)
+	if !ok {
+		return "", nil, fmt.Errorf(`first argument to *define* must be symbol, received %v`, reflect.TypeOf(target))
+	}
+	if len(body) != 1 {
+		return "", nil, arityError{expected: 2, received: len(args), name: "define"}
+	}
+	return s, body[0], nil
+}
+
+// the symbol that a define defines, given its first argument.
+func definedName(target interface{}) (symbol, bool) {
+	for {
+		p, ok := target.(*pair)
+		if !ok {
+			break
+		}
+		target = p.Car
+	}
+	s, ok := target.(symbol)
+	return s, ok
+}
+
 // defines the built-in "quote" construct.  e.g.:
 //
 //  (quote (1 2 3))
@@ -106,21 +181,128 @@ var define = special{
 // would evaluate to the list (1 2 3).  That is, quote is a function of arity 1
 // that is effectively a no-op; the input value is not evaluated, which
 // prevents evaluation of the first element of the list, in this case 1.
-var quote = special{
+var quote = &special{
 	name:  "quote",
 	arity: 1,
 	fn: func(_ *environment, args []interface{}) (interface{}, error) {
-		switch t := args[0].(type) {
-		case *sexp:
-			t.quotelvl++
-			return t, nil
-		default:
-			return &sexp{items: []interface{}{t}, quotelvl: 1}, nil
+		return args[0], nil
+	},
+}
+
+// defines the built-in "quasiquote" construct.  e.g.:
+//
+//  (quasiquote (1 (unquote (+ 1 1)) (unquote-splicing (list 3 4))))
+//
+// would evaluate to the list (1 2 3 4).  A quasiquote works like a quote,
+// except that unquoted values inside of the template are evaluated, and
+// the values of spliced unquotes, which must be lists, have their items
+// inserted into the enclosing list or vector.  Quasiquotes may be nested, in which case
+// an unquote only belongs to the innermost quasiquote; unquotes inside of a
+// nested quasiquote are left in place.
+var quasiquote = &special{
+	name:  "quasiquote",
+	arity: 1,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		return tailCall{qq(args[0], 1), env}, nil
+	},
+}
+
+// expands the quasiquote template v, where depth is the number of
+// quasiquotes we're nested inside of, into an expression that builds the
+// template's value.  The expression calls the quote special and the cons and
+// list builtins directly instead of by name, so it means the same thing no
+// matter what those names have been defined as.
+func qq(v interface{}, depth int) interface{} {
+	if vec, ok := v.(*vector); ok {
+		return qqVector(vec, depth)
+	}
+	p, ok := v.(*pair)
+	if !ok {
+		return reader.ListFrom([]interface{}{quote, v}, null)
+	}
+
+	if name, arg, ok := qqForm(p); ok {
+		switch name {
+		case "unquote":
+			if depth == 1 {
+				return arg
+			}
+			return qqWrap(name, arg, depth-1)
+		case "quasiquote":
+			return qqWrap(name, arg, depth+1)
+		}
+	}
+
+	// expanding the cdr first means that a dotted unquote like `(1 . ,x) is
+	// handled by the unquote case above.
+	return qqItem(p.Car, qq(p.Cdr, depth), depth)
+}
+
+// expands the template item v, which is followed by the list that the
+// expression rest builds, into an expression that puts v's value in front of
+// that list, or v's items if v is an unquote-splicing.
+func qqItem(v, rest interface{}, depth int) interface{} {
+	if child, ok := v.(*pair); ok {
+		if name, arg, ok := qqForm(child); ok && name == "unquote-splicing" {
+			if depth > 1 {
+				return reader.ListFrom([]interface{}{cons, qqWrap(name, arg, depth-1), rest}, null)
+			}
+			return reader.ListFrom([]interface{}{splice, arg, rest}, null)
 		}
-		panic("not reached")
+	}
+	return reader.ListFrom([]interface{}{cons, qq(v, depth), rest}, null)
+}
+
+// expands a vector template, like `#(1 ,x ,@y), the same way as a list
+// template, and turns the list into a vector.  The items are expanded one at a
+// time, so that a vector like #(unquote x) isn't mistaken for an unquote.
+func qqVector(v *vector, depth int) interface{} {
+	rest := reader.ListFrom([]interface{}{quote, null}, null)
+	for i := len(v.Items) - 1; i >= 0; i-- {
+		rest = qqItem(v.Items[i], rest, depth)
+	}
+	return reader.ListFrom([]interface{}{listToVector, rest}, null)
+}
+
+// splices the items of a list in front of another list, for
+// unquote-splicing.
+var splice = &builtin{
+	name:  "unquote-splicing",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		items, err := listItems(vals[0])
+		if err != nil {
+			return nil, fmt.Errorf(`*unquote-splicing* expects a list: %v`, err)
+		}
+		return reader.ListFrom(items, vals[1]), nil
 	},
 }
 
+// returns the name and argument of p if p is a quasiquote, unquote or
+// unquote-splicing form.
+func qqForm(p *pair) (symbol, interface{}, bool) {
+	name, ok := p.Car.(symbol)
+	if !ok {
+		return "", nil, false
+	}
+	switch name {
+	case "quasiquote", "unquote", "unquote-splicing":
+	default:
+		return "", nil, false
+	}
+	rest, ok := p.Cdr.(*pair)
+	if !ok || rest.Cdr != null {
+		return "", nil, false
+	}
+	return name, rest.Car, true
+}
+
+// expands to an expression that rebuilds the form (name arg), expanding arg
+// at the given depth.
+func qqWrap(name symbol, arg interface{}, depth int) interface{} {
+	return reader.ListFrom([]interface{}{lst, reader.ListFrom([]interface{}{quote, name}, null), qq(arg, depth)}, null)
+}
+
 // turns an arbitrary lisp value into a boolean.  Apparently the sematics of
 // this in lisp are that everything except false is true?  Seems weird to me,
 // but ok.
@@ -140,7 +322,7 @@ func booleanize(v interface{}) bool {
 //  (if #f "foo" "bar")
 //
 // would evaluate to "bar"
-var _if = special{
+var _if = &special{
 	name:     "if",
 	arity:    2,
 	variadic: true,
@@ -153,18 +335,15 @@ var _if = special{
 				variadic: false,
 			}
 		}
-		v, err := eval(args[0], env)
-		if err != nil {
-			return nil, err
-		}
-
-		if booleanize(v) {
-			return eval(args[1], env)
-		}
-		if len(args) == 3 {
-			return eval(args[2], env)
-		}
-		return nil, nil
+		return evalThen{args[0], env, func(v interface{}) (interface{}, error) {
+			if booleanize(v) {
+				return tailCall{args[1], env}, nil
+			}
+			if len(args) == 3 {
+				return tailCall{args[2], env}, nil
+			}
+			return unspecified, nil
+		}}, nil
 	},
 }
 
@@ -174,8 +353,9 @@ var _if = special{
 //  (set! x 5)
 //
 // would set the symbol x to the value 5, if and only if the symbol x was
-// previously defined.
-var set = special{
+// previously defined.  The binding is changed in the environment that defines
+// it, so a closure can update a variable that it closed over.
+var set = &special{
 	name:  "set!",
 	arity: 2,
 	fn: func(env *environment, args []interface{}) (interface{}, error) {
@@ -188,79 +368,359 @@ var set = special{
 			return nil, fmt.Errorf(`cannot *set!* undefined symbol %v`, s)
 		}
 
-		v, err := eval(args[1], env)
-		if err != nil {
-			return nil, err
-		}
-		env.set(s, v)
-
-		return nil, nil
+		return evalThen{args[1], env, func(v interface{}) (interface{}, error) {
+			if err := env.update(s, v); err != nil {
+				return nil, err
+			}
+			return unspecified, nil
+		}}, nil
 	},
 }
 
 type lambda struct {
-	env       *environment
-	arglabels []symbol
-	body      *sexp
+	env    *environment
+	name   string // the name that the lambda was defined as, if any
+	params formals
+	body   []interface{}
+
+	// the names defined by the define forms at the top of the body
+	defines []symbol
 }
 
-func (l lambda) call(env *environment, rawArgs []interface{}) (interface{}, error) {
+func (l *lambda) String() string {
+	if l.name == "" {
+		return "#<procedure>"
+	}
+	return "#<procedure " + l.name + ">"
+}
+
+func (l *lambda) call(env *environment, rawArgs []interface{}) (interface{}, error) {
 	debugPrint("call lambda")
+	return evalArgs(rawArgs, env, l.invoke)
+}
 
-	args := make([]interface{}, 0, len(rawArgs))
-	for _, raw := range rawArgs {
-		v, err := eval(raw, env)
-		if err != nil {
-			return nil, err
+// calls the lambda with arguments that have already been evaluated.  Each
+// call gets its own environment for its arguments, inside of the environment
+// that the lambda was defined in, so recursive calls don't clobber each
+// other's arguments.  The body is evaluated in tail position.
+func (l *lambda) invoke(args []interface{}) (interface{}, error) {
+	if !l.params.accepts(len(args)) {
+		return nil, l.params.arityError(l.errorName(), len(args))
+	}
+
+	env := newEnvironment(l.env)
+	for _, s := range l.defines {
+		env.set(s, unassigned)
+	}
+	p := l.params
+	for i, s := range p.required {
+		env.set(s, args[i])
+	}
+	args = args[len(p.required):]
+	if p.rest != "" {
+		var rest interface{} = null
+		if len(args) > len(p.optional) {
+			rest = reader.ListFrom(args[len(p.optional):], null)
 		}
-		args = append(args, v)
+		env.set(p.rest, rest)
 	}
+	return l.bindOptional(env, args, 0)
+}
 
-	if len(args) != len(l.arglabels) {
-		return nil, errors.New("parity error")
+// binds the lambda's #!optional parameters, from the ith one on, to the
+// arguments in args, and then evaluates the body.  The defaults of parameters
+// that weren't passed are evaluated in env, one at a time, so a default can
+// refer to the parameters before it.
+func (l *lambda) bindOptional(env *environment, args []interface{}, i int) (interface{}, error) {
+	for ; i < len(l.params.optional); i++ {
+		p := l.params.optional[i]
+		switch {
+		case i < len(args):
+			env.set(p.name, args[i])
+		case p.def == nil:
+			env.set(p.name, false)
+		default:
+			next := i + 1
+			return evalThen{p.def, env, func(v interface{}) (interface{}, error) {
+				env.set(p.name, v)
+				return l.bindOptional(env, args, next)
+			}}, nil
+		}
 	}
+	return evalBody(l.body, env)
+}
+
+func (l *lambda) apply(args []interface{}) (interface{}, error) {
+	return exec(l.invoke(args))
+}
 
-	for i := range args {
-		l.env.set(l.arglabels[i], args[i])
+// the name that the lambda goes by in error messages.
+func (l *lambda) errorName() string {
+	if l.name == "" {
+		return "lambda"
 	}
+	return l.name
+}
+
+// type formals is the parameter list of a lambda.  It can have parameters
+// that are required, then parameters that are optional, and then one last
+// parameter that gets a list of any remaining arguments:
+//
+//  (lambda (a b) ...)                      exactly two arguments
+//  (lambda (a b . rest) ...)               two or more
+//  (lambda args ...)                       any number at all
+//  (lambda (a #!optional (b 2) c) ...)     one to three
+//
+// An optional parameter that's left out gets the value of its default, or #f
+// if it doesn't have one.
+type formals struct {
+	required []symbol
+	optional []optionalParam
+	rest     symbol // "" if there's no rest parameter
+}
 
-	return eval(l.body, l.env)
+type optionalParam struct {
+	name symbol
+	def  interface{} // the default expression, or nil if there isn't one
+}
+
+// the marker that starts the optional parameters of a lambda.
+const optionalMarker = symbol("#!optional")
+
+// parses the parameter list of a lambda.
+func parseFormals(v interface{}) (formals, error) {
+	var f formals
+	seen := make(map[symbol]bool)
+	param := func(v interface{}) (symbol, error) {
+		s, ok := v.(symbol)
+		if !ok || isKeyword(s, optionalMarker) {
+			return "", fmt.Errorf(`lambda args must all be symbols; received invalid %v`, repr(v))
+		}
+		if seen[s] {
+			return "", fmt.Errorf(`lambda arg %v appears more than once`, s)
+		}
+		seen[s] = true
+		return s, nil
+	}
+
+	optional := false
+	for {
+		switch t := v.(type) {
+		case emptyList:
+			return f, nil
+		case symbol:
+			s, err := param(t)
+			if err != nil {
+				return f, err
+			}
+			f.rest = s
+			return f, nil
+		case *pair:
+			switch {
+			case isKeyword(t.Car, optionalMarker):
+				if optional {
+					return f, errors.New(`#!optional appears more than once in lambda args`)
+				}
+				optional = true
+			case optional:
+				p, err := parseOptional(t.Car, param)
+				if err != nil {
+					return f, err
+				}
+				f.optional = append(f.optional, p)
+			default:
+				s, err := param(t.Car)
+				if err != nil {
+					return f, err
+				}
+				f.required = append(f.required, s)
+			}
+			v = t.Cdr
+		default:
+			return f, fmt.Errorf(`first argument to *lambda* must be a list or symbol, received %v`, reflect.TypeOf(v))
+		}
+	}
+}
+
+// parses an optional parameter, which is either a symbol or a list of a
+// symbol and its default expression.
+func parseOptional(v interface{}, param func(interface{}) (symbol, error)) (optionalParam, error) {
+	if _, ok := v.(*pair); !ok {
+		s, err := param(v)
+		return optionalParam{name: s}, err
+	}
+	items, err := listItems(v)
+	if err != nil || len(items) != 2 {
+		return optionalParam{}, fmt.Errorf(`optional lambda arg must be a symbol or (symbol default), received %v`, repr(v))
+	}
+	s, err := param(items[0])
+	return optionalParam{s, items[1]}, err
+}
+
+// whether a lambda with these formals can be called with n arguments.
+func (f formals) accepts(n int) bool {
+	if n < len(f.required) {
+		return false
+	}
+	return f.rest != "" || n <= len(f.required)+len(f.optional)
+}
+
+func (f formals) arityError(name string, received int) arityError {
+	return arityError{
+		expected: len(f.required),
+		received: received,
+		name:     name,
+		variadic: f.rest != "",
+		optional: len(f.optional),
+	}
 }
 
 // defines the built-in lambda construct.  e.g.:
 //
 //  (lambda (x) (* x x))
 //
-// would evaluate to a lambda that, when executed, squares its input.
-var mklambda = special{
-	name:  "lambda",
-	arity: 2,
+// would evaluate to a lambda that, when executed, squares its input.  See
+// formals for the parameter lists that lambda accepts.  The body can be any
+// number of expressions, which are evaluated in order, as with begin.  The
+// body can start with define forms, which define variables local to each
+// call.  Those variables all exist from the start of the call, as if by
+// letrec*, so the procedures that they define can call each other, but
+// using one before its define has been evaluated is an error.
+var mklambda = &special{
+	name:     "lambda",
+	arity:    2,
+	variadic: true,
 	fn: func(env *environment, args []interface{}) (interface{}, error) {
 		debugPrint("mklambda")
+		return makeLambda(env, args[0], args[1:])
+	},
+}
+
+func makeLambda(env *environment, params interface{}, body []interface{}) (*lambda, error) {
+	f, err := parseFormals(params)
+	if err != nil {
+		return nil, err
+	}
+	return newLambda(env, f, body), nil
+}
 
-		params, ok := args[0].(*sexp)
+// makes a lambda out of parsed formals, finding the names defined at the top
+// of its body.
+func newLambda(env *environment, f formals, body []interface{}) *lambda {
+	var defines []symbol
+	for _, form := range body {
+		p, ok := form.(*pair)
+		if ok && isKeyword(p.Car, "define-syntax") {
+			continue
+		}
+		if !ok || !isKeyword(p.Car, "define") {
+			break
+		}
+		target, ok := p.Cdr.(*pair)
 		if !ok {
-			return nil, fmt.Errorf(`first argument to *lambda* must be sexp, received %v`, reflect.TypeOf(args[0]))
+			break
 		}
-
-		arglabels := make([]symbol, 0, len(params.items))
-		for _, v := range params.items {
-			s, ok := v.(symbol)
-			if !ok {
-				return nil, fmt.Errorf(`lambda args must all be symbols; received invalid %v`, reflect.TypeOf(v))
-			}
-			arglabels = append(arglabels, s)
+		if s, ok := definedName(target.Car); ok {
+			defines = append(defines, s)
 		}
+	}
 
-		body, ok := args[1].(*sexp)
-		if !ok {
-			return nil, fmt.Errorf(`second argument to *lambda* must be sexp, received %v`, reflect.TypeOf(args[1]))
+	return &lambda{env: env, params: f, body: body, defines: defines}
+}
+
+// type caseLambda is a procedure made of several lambdas, which calls the
+// first one that accepts the number of arguments that it was called with.
+type caseLambda struct {
+	name    string
+	clauses []*lambda
+}
+
+func (c *caseLambda) String() string {
+	if c.name == "" {
+		return "#<procedure>"
+	}
+	return "#<procedure " + c.name + ">"
+}
+
+func (c *caseLambda) call(env *environment, rawArgs []interface{}) (interface{}, error) {
+	return evalArgs(rawArgs, env, c.invoke)
+}
+
+func (c *caseLambda) invoke(args []interface{}) (interface{}, error) {
+	for _, l := range c.clauses {
+		if l.params.accepts(len(args)) {
+			return l.invoke(args)
 		}
+	}
+
+	name := c.name
+	if name == "" {
+		name = "case-lambda"
+	}
+	err := arityError{received: len(args), name: name}
+	for _, l := range c.clauses {
+		err.clauses = append(err.clauses, l.params.arityError(name, len(args)))
+	}
+	return nil, err
+}
 
-		return lambda{env, arglabels, body}, nil
+func (c *caseLambda) apply(args []interface{}) (interface{}, error) {
+	return exec(c.invoke(args))
+}
+
+// defines the built-in case-lambda construct, which makes a procedure that
+// picks what to do based on how many arguments it's called with.  e.g.:
+//
+//  (case-lambda
+//    ((x) (* x x))
+//    ((x y) (* x y)))
+//
+// would evaluate to a procedure that squares one argument, or multiplies two.
+// Each clause has the parameter list and body of a lambda, and the first
+// clause that accepts the arguments is the one that's called.
+var mkcaseLambda = &special{
+	name:     "case-lambda",
+	arity:    1,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		c := &caseLambda{clauses: make([]*lambda, 0, len(args))}
+		for _, clause := range args {
+			items, err := listItems(clause)
+			if err != nil || len(items) < 2 {
+				return nil, fmt.Errorf(`*case-lambda* clauses must be lists of parameters and a body, received %v`, repr(clause))
+			}
+			l, err := makeLambda(env, items[0], items[1:])
+			if err != nil {
+				return nil, err
+			}
+			c.clauses = append(c.clauses, l)
+		}
+		return c, nil
 	},
 }
 
+// gives an anonymous procedure or macro the name that it's being defined as,
+// so that it shows up in error messages.
+func named(v interface{}, s symbol) interface{} {
+	switch t := v.(type) {
+	case *lambda:
+		if t.name == "" {
+			t.name = string(plain(s))
+			return t
+		}
+	case *caseLambda:
+		if t.name == "" {
+			t.name = string(plain(s))
+			return t
+		}
+	case *syntaxRules:
+		if t.name == "" {
+			t.name = string(plain(s))
+		}
+	}
+	return v
+}
+
 // defines the built-in "begin" construct.  A "begin" statement evaluates each
 // of its inputs, and returns the value of the evaluation of the last
 // statement.  E.g.:
@@ -268,64 +728,538 @@ var mklambda = special{
 //  (begin (+ 1 1) (* 2 2) (+ 3 3))
 //
 // would evaluate to 6.
-var begin = special{
+var begin = &special{
 	name:     "begin",
 	variadic: true,
 	fn: func(env *environment, args []interface{}) (interface{}, error) {
 		debugPrint("begin")
-		var err error
-		var v interface{}
-		for _, arg := range args {
-			v, err = eval(arg, env)
-			if err != nil {
-				return nil, err
+		if len(args) == 0 {
+			return unspecified, nil
+		}
+		return evalBody(args, env)
+	},
+}
+
+// type binding is one of the bindings of a let form, like (x 5), or of a do
+// loop, which can also have a step, like (x 5 (+ x 1)).
+type binding struct {
+	name symbol
+	expr interface{}
+	step interface{} // nil if there isn't one
+}
+
+// parses the list of bindings of the let form called name.  Unless
+// duplicates is set, each name can only be bound once, and unless steps is
+// set, bindings can't have steps.  Errors point at the binding that's wrong,
+// when it has a position.
+func parseBindings(name string, v interface{}, duplicates, steps bool) ([]binding, error) {
+	pos := posOf(v, position{})
+	items, err := listItems(v)
+	if err != nil {
+		return nil, errorAt(pos, fmt.Errorf(`bindings of *%s* must be a list: %v`, name, err))
+	}
+
+	bs := make([]binding, 0, len(items))
+	seen := make(map[symbol]bool, len(items))
+	for _, item := range items {
+		at := posOf(item, pos)
+		parts, err := listItems(item)
+		switch {
+		case steps && (err != nil || len(parts) < 2 || len(parts) > 3):
+			return nil, errorAt(at, fmt.Errorf(`bad binding %v in *%s*, expected (name value) or (name value step)`, repr(item), name))
+		case !steps && (err != nil || len(parts) != 2):
+			return nil, errorAt(at, fmt.Errorf(`bad binding %v in *%s*, expected (name value)`, repr(item), name))
+		}
+		s, ok := parts[0].(symbol)
+		if !ok {
+			return nil, errorAt(at, fmt.Errorf(`bad binding %v in *%s*, name must be symbol, received %v`, repr(item), name, reflect.TypeOf(parts[0])))
+		}
+		if seen[s] && !duplicates {
+			return nil, errorAt(at, fmt.Errorf(`bad binding %v in *%s*, %v is already bound`, repr(item), name, s))
+		}
+		seen[s] = true
+		b := binding{name: s, expr: parts[1]}
+		if len(parts) == 3 {
+			b.step = parts[2]
+		}
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+func bindingNames(bs []binding) []symbol {
+	names := make([]symbol, len(bs))
+	for i, b := range bs {
+		names[i] = b.name
+	}
+	return names
+}
+
+func bindingExprs(bs []binding) []interface{} {
+	exprs := make([]interface{}, len(bs))
+	for i, b := range bs {
+		exprs[i] = b.expr
+	}
+	return exprs
+}
+
+// evaluates the body of a let form in a new environment inside of env.  The
+// body is treated just like a lambda body, so it can start with defines.
+func evalLocal(env *environment, body []interface{}) (interface{}, error) {
+	return newLambda(env, formals{}, body).invoke(nil)
+}
+
+// defines the built-in "let" construct, which binds some names to values for
+// the duration of its body.  e.g.:
+//
+//  (let ((x 2) (y 3)) (* x y))
+//
+// would evaluate to 6.  The values are all evaluated before any of the names
+// are bound, so they can't refer to each other.  There's also the named let,
+// which binds a name to a procedure whose body is the body of the let, and
+// then calls it with the values.  It's mostly used for loops:
+//
+//  (let loop ((i 0) (total 0))
+//    (if (= i 5) total (loop (+ i 1) (+ total i))))
+//
+// would evaluate to 10.
+var let = &special{
+	name:     "let",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		name, named := args[0].(symbol)
+		if named {
+			if len(args) < 3 {
+				return nil, arityError{expected: 3, received: len(args), name: "let", variadic: true}
 			}
+			args = args[1:]
 		}
-		return v, nil
+
+		bs, err := parseBindings("let", args[0], false, false)
+		if err != nil {
+			return nil, err
+		}
+		f := formals{required: bindingNames(bs)}
+
+		if !named {
+			return evalArgs(bindingExprs(bs), env, newLambda(env, f, args[1:]).invoke)
+		}
+		loop := newEnvironment(env)
+		l := newLambda(loop, f, args[1:])
+		l.name = string(name)
+		loop.set(name, l)
+		return evalArgs(bindingExprs(bs), env, l.invoke)
+	},
+}
+
+// defines the built-in "let*" construct, which is like let, except that the
+// values are evaluated and bound one at a time, so each one can refer to the
+// names bound before it.  e.g.:
+//
+//  (let* ((x 2) (y (* x 3))) y)
+//
+// would evaluate to 6.
+var letStar = &special{
+	name:     "let*",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		bs, err := parseBindings("let*", args[0], true, false)
+		if err != nil {
+			return nil, err
+		}
+		return evalLetStar(env, bs, args[1:])
 	},
 }
 
-var names = special{
+func evalLetStar(env *environment, bs []binding, body []interface{}) (interface{}, error) {
+	if len(bs) == 0 {
+		return evalLocal(env, body)
+	}
+	return evalThen{bs[0].expr, env, func(v interface{}) (interface{}, error) {
+		inner := newEnvironment(env)
+		inner.set(bs[0].name, v)
+		return evalLetStar(inner, bs[1:], body)
+	}}, nil
+}
+
+// defines the built-in "letrec" construct, which is like let, except that the
+// values are evaluated inside of the new environment, so they can refer to
+// the names being bound.  That's how locally defined procedures can call
+// each other:
+//
+//  (letrec ((ev? (lambda (n) (if (= n 0) #t (od? (- n 1)))))
+//           (od? (lambda (n) (if (= n 0) #f (ev? (- n 1))))))
+//    (ev? 10))
+//
+// would evaluate to #t.  Using one of the names before all of the values have
+// been evaluated is an error.
+var letrec = &special{
+	name:     "letrec",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		bs, err := parseBindings("letrec", args[0], false, false)
+		if err != nil {
+			return nil, err
+		}
+		inner := newEnvironment(env)
+		for _, b := range bs {
+			inner.set(b.name, unassigned)
+		}
+		return evalArgs(bindingExprs(bs), inner, func(vals []interface{}) (interface{}, error) {
+			for i, b := range bs {
+				inner.set(b.name, named(vals[i], b.name))
+			}
+			return evalLocal(inner, args[1:])
+		})
+	},
+}
+
+// defines the built-in "letrec*" construct, which is like letrec, except that
+// each name is bound as soon as its value has been evaluated, so later values
+// can use the earlier names right away.
+var letrecStar = &special{
+	name:     "letrec*",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		bs, err := parseBindings("letrec*", args[0], false, false)
+		if err != nil {
+			return nil, err
+		}
+		inner := newEnvironment(env)
+		for _, b := range bs {
+			inner.set(b.name, unassigned)
+		}
+		return evalLetrecStar(inner, bs, args[1:])
+	},
+}
+
+func evalLetrecStar(env *environment, bs []binding, body []interface{}) (interface{}, error) {
+	if len(bs) == 0 {
+		return evalLocal(env, body)
+	}
+	return evalThen{bs[0].expr, env, func(v interface{}) (interface{}, error) {
+		env.set(bs[0].name, named(v, bs[0].name))
+		return evalLetrecStar(env, bs[1:], body)
+	}}, nil
+}
+
+var names = &special{
 	name:     "names",
 	arity:    0,
 	variadic: false,
 	fn: func(env *environment, args []interface{}) (interface{}, error) {
-		return env.keys(), nil
+		keys := env.keys()
+		items := make([]interface{}, len(keys))
+		for i, key := range keys {
+			items[i] = symbol(key)
+		}
+		return reader.ListFrom(items, null), nil
 	},
 }
 
-var and = special{
+// defines the built-in "and" construct, which evaluates its arguments until
+// one of them is false.  Its value is #f if one of them was, and otherwise the
+// value of the last one, which is evaluated in tail position.
+var and = &special{
 	name:     "and",
 	arity:    1,
 	variadic: true,
-	fn: func(env *environment, args []interface{}) (interface{}, error) {
-		for _, v := range args {
-			t, err := eval(v, env)
+	fn:       evalAnd,
+}
+
+func evalAnd(env *environment, args []interface{}) (interface{}, error) {
+	if len(args) == 1 {
+		return tailCall{args[0], env}, nil
+	}
+	return evalThen{args[0], env, func(v interface{}) (interface{}, error) {
+		if !booleanize(v) {
+			return false, nil
+		}
+		return evalAnd(env, args[1:])
+	}}, nil
+}
+
+// defines the built-in "or" construct, which evaluates its arguments until
+// one of them is true, and has that argument's value.  The last argument is
+// evaluated in tail position.
+var or = &special{
+	name:     "or",
+	arity:    1,
+	variadic: true,
+	fn:       evalOr,
+}
+
+func evalOr(env *environment, args []interface{}) (interface{}, error) {
+	if len(args) == 1 {
+		return tailCall{args[0], env}, nil
+	}
+	return evalThen{args[0], env, func(v interface{}) (interface{}, error) {
+		if booleanize(v) {
+			return v, nil
+		}
+		return evalOr(env, args[1:])
+	}}, nil
+}
+
+// the symbols that mark the else clauses of cond and case, and the clauses
+// whose value is passed to a procedure.
+const (
+	elseSymbol  = symbol("else")
+	arrowSymbol = symbol("=>")
+)
+
+// the position of v, if it's a list that has one, or otherwise fallback.
+func posOf(v interface{}, fallback position) position {
+	if p, ok := v.(*pair); ok && p.Pos.IsValid() {
+		return p.Pos
+	}
+	return fallback
+}
+
+// type clause is a clause of a cond or case form.  Its body is either a list
+// of expressions to evaluate, or, for a clause with an arrow, an expression
+// for a procedure that's called with the clause's value.
+type clause struct {
+	head   interface{} // the test of a cond clause, or the data of a case clause
+	body   []interface{}
+	arrow  bool
+	isElse bool
+}
+
+// parses the clauses of the cond or case form called name.  Only the last
+// clause can be an else clause.  In a cond, a clause can have a test and no
+// body.
+func parseClauses(name string, args []interface{}, pos position) ([]clause, error) {
+	cs := make([]clause, 0, len(args))
+	for i, arg := range args {
+		at := posOf(arg, pos)
+		items, err := listItems(arg)
+		if err != nil || len(items) == 0 {
+			return nil, errorAt(at, fmt.Errorf(`bad clause %v in *%s*, expected a non-empty list`, repr(arg), name))
+		}
+		c := clause{head: items[0], body: items[1:], isElse: isKeyword(items[0], elseSymbol)}
+		if c.isElse && i != len(args)-1 {
+			return nil, errorAt(at, fmt.Errorf(`else clause %v in *%s* must be the last clause`, repr(arg), name))
+		}
+		if len(c.body) > 0 && isKeyword(c.body[0], arrowSymbol) {
+			if len(c.body) != 2 {
+				return nil, errorAt(at, fmt.Errorf(`bad clause %v in *%s*, => must be followed by exactly one expression`, repr(arg), name))
+			}
+			c.arrow, c.body = true, c.body[1:]
+		}
+		if len(c.body) == 0 && (c.isElse || name != "cond") {
+			return nil, errorAt(at, fmt.Errorf(`bad clause %v in *%s*, expected at least one expression`, repr(arg), name))
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// evaluates the body of the clause c, given the value v that chose it.
+func (c clause) eval(env *environment, v interface{}) (interface{}, error) {
+	switch {
+	case c.arrow:
+		return evalThen{c.body[0], env, func(f interface{}) (interface{}, error) {
+			proc, err := procedureArg("=>", f)
 			if err != nil {
-				return false, err
+				return nil, err
 			}
-			if !booleanize(t) {
-				return false, nil
+			return proc.invoke([]interface{}{v})
+		}}, nil
+	case len(c.body) == 0:
+		return v, nil
+	}
+	return evalBody(c.body, env)
+}
+
+// defines the built-in "cond" construct, which evaluates the tests of its
+// clauses in order until one of them is true, and then evaluates the rest of
+// that clause.  e.g.:
+//
+//  (cond ((< x 0) "negative")
+//        ((assv x table) => cdr)
+//        (else "something else"))
+//
+// A clause with => calls the procedure after it with the value of the test,
+// and a clause with nothing but a test has the value of the test.  If no test
+// is true and there's no else clause, the value is unspecified.
+var cond = &special{
+	name:     "cond",
+	arity:    1,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		cs, err := parseClauses("cond", args, position{})
+		if err != nil {
+			return nil, err
+		}
+		return evalCond(env, cs)
+	},
+}
+
+func evalCond(env *environment, cs []clause) (interface{}, error) {
+	if len(cs) == 0 {
+		return unspecified, nil
+	}
+	c := cs[0]
+	if c.isElse {
+		return c.eval(env, true)
+	}
+	return evalThen{c.head, env, func(v interface{}) (interface{}, error) {
+		if !booleanize(v) {
+			return evalCond(env, cs[1:])
+		}
+		return c.eval(env, v)
+	}}, nil
+}
+
+// defines the built-in "case" construct, which evaluates a key and then picks
+// the first clause whose list of data has a datum that's eqv? to the key.
+// e.g.:
+//
+//  (case (* 2 3)
+//    ((2 3 5 7) "prime")
+//    ((1 4 6 8 9) "composite")
+//    (else "big"))
+//
+// would evaluate to "composite".  A clause with => calls the procedure after
+// it with the key.
+var _case = &special{
+	name:     "case",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		cs, err := parseClauses("case", args[1:], position{})
+		if err != nil {
+			return nil, err
+		}
+		data := make([][]interface{}, len(cs))
+		for i, c := range cs {
+			if c.isElse {
+				continue
+			}
+			if data[i], err = listItems(c.head); err != nil {
+				return nil, errorAt(posOf(args[i+1], position{}), fmt.Errorf(`bad clause %v in *case*, data must be a list, received %v`, repr(args[i+1]), repr(c.head)))
 			}
 		}
-		return true, nil
+
+		return evalThen{args[0], env, func(key interface{}) (interface{}, error) {
+			for i, c := range cs {
+				if c.isElse {
+					return c.eval(env, key)
+				}
+				for _, d := range data[i] {
+					if eqv(d, key) {
+						return c.eval(env, key)
+					}
+				}
+			}
+			return unspecified, nil
+		}}, nil
 	},
 }
 
-var or = special{
-	name:     "or",
+// defines the built-in "when" construct, which evaluates its body only if its
+// test is true.  e.g.:
+//
+//  (when (> x 10) (display "big") x)
+var when = &special{
+	name:     "when",
 	arity:    1,
 	variadic: true,
 	fn: func(env *environment, args []interface{}) (interface{}, error) {
-		for _, v := range args {
-			t, err := eval(v, env)
-			if err != nil {
-				return false, err
-			}
-			if booleanize(t) {
-				return true, nil
+		return evalWhen(env, args, true)
+	},
+}
+
+// defines the built-in "unless" construct, which evaluates its body only if
+// its test is false.
+var unless = &special{
+	name:     "unless",
+	arity:    1,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		return evalWhen(env, args, false)
+	},
+}
+
+// evaluates the body of a when or unless form, if its test comes out as want.
+func evalWhen(env *environment, args []interface{}, want bool) (interface{}, error) {
+	return evalThen{args[0], env, func(v interface{}) (interface{}, error) {
+		if booleanize(v) != want || len(args) == 1 {
+			return unspecified, nil
+		}
+		return evalBody(args[1:], env)
+	}}, nil
+}
+
+// defines the built-in "do" construct, which is a loop.  e.g.:
+//
+//  (do ((i 0 (+ i 1))
+//       (total 0 (+ total i)))
+//      ((= i 5) total)
+//    (display i))
+//
+// binds i and total to their initial values, and then, until the test
+// (= i 5) is true, evaluates the body and then rebinds each variable to the
+// value of its step.  Variables without a step keep their values.  Once the
+// test is true, the rest of that list is evaluated, and the last of it is the
+// value of the loop, which is 10 here.  Each time around the loop gets a new
+// environment, so closures made by the body each see their own variables.
+// The loop runs in constant space, however many times it goes around.
+var do = &special{
+	name:     "do",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		bs, err := parseBindings("do", args[0], false, true)
+		if err != nil {
+			return nil, err
+		}
+		exit, err := listItems(args[1])
+		if err != nil || len(exit) == 0 {
+			return nil, errorAt(posOf(args[1], position{}), fmt.Errorf(`second argument to *do* must be a list of a test and results, received %v`, repr(args[1])))
+		}
+
+		steps := make([]interface{}, len(bs))
+		for i, b := range bs {
+			steps[i] = b.step
+			if steps[i] == nil {
+				steps[i] = b.name
 			}
 		}
-		return false, nil
+		loop := doLoop{env, bindingNames(bs), steps, exit[0], exit[1:], args[2:]}
+		return evalArgs(bindingExprs(bs), env, loop.next)
 	},
 }
+
+type doLoop struct {
+	env      *environment // the environment that the loop is in
+	names    []symbol
+	steps    []interface{}
+	test     interface{}
+	results  []interface{}
+	commands []interface{}
+}
+
+// goes around the loop once more, with the variables bound to vals.
+func (l doLoop) next(vals []interface{}) (interface{}, error) {
+	env := newEnvironment(l.env)
+	for i, s := range l.names {
+		env.set(s, vals[i])
+	}
+	return evalThen{l.test, env, func(v interface{}) (interface{}, error) {
+		if booleanize(v) {
+			if len(l.results) == 0 {
+				return unspecified, nil
+			}
+			return evalBody(l.results, env)
+		}
+		return evalArgs(l.commands, env, func([]interface{}) (interface{}, error) {
+			return evalArgs(l.steps, env, l.next)
+		})
+	}}, nil
+}
@@ -6,6 +6,10 @@ var isSendMessage = function(event) {
   return event.keyCode == 13 && event.shiftKey;
 }
 
+var isNewline = function(event) {
+  return event.keyCode == 13 && !event.shiftKey;
+}
+
 var InputHandler = function(selector) {
   this.elem = $(selector);
   this.elem.keydown(_.bind(this.keydown, this));
@@ -15,6 +19,40 @@ InputHandler.prototype.keydown = function(event) {
   if (isSendMessage(event)) {
     return this.handleSend(event);
   }
+  if (isNewline(event)) {
+    return this.handleNewline(event);
+  }
+};
+
+// starts a new line right away, then asks the server whether the input is
+// made up of complete forms.  If it is, and the input hasn't been changed in
+// the meantime, the input is sent off.  Otherwise the user is still in the
+// middle of a form, and the new line stays where it is.
+InputHandler.prototype.handleNewline = function(event) {
+  event.preventDefault();
+  event.stopPropagation();
+  var target = event.target;
+  var input = target.value;
+  var start = target.selectionStart;
+  var edited = input.slice(0, start) + "\n" + input.slice(target.selectionEnd);
+  target.value = edited;
+  target.selectionStart = target.selectionEnd = start + 1;
+  $.ajax({
+    url: "/complete",
+    type: "POST",
+    data: input,
+    contentType: "text/plain",
+    dataType: "json",
+    success: _.bind(function(response) {
+      if (response.complete && $.trim(input) !== "" && target.value === edited) {
+        this.sendMessage(input);
+        this.clear();
+      }
+    }, this),
+    error: function(xhr, status, error) {
+      console.log({error: error || status});
+    }
+  });
 };
 
 InputHandler.prototype.handleSend = function(event) {
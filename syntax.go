@@ -0,0 +1,758 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Macros are written with syntax-rules, which rewrites a form by matching it
+// against patterns and filling in the template of the first pattern that
+// matches.  Macros are hygienic: every symbol that a template puts into the
+// expansion, other than the pattern variables, is renamed to an alias that's
+// unique to that expansion.  An alias is a symbol like tmp{2:3}, which the
+// reader can't produce, so it can never clash with a symbol in the program.
+// An alias also names the macro that made it, and an environment knows which
+// macros were defined in it, so looking up an alias that nothing in the
+// expansion has bound falls back to looking up the original symbol in the
+// environment that the macro was defined in.  So a variable that a
+// template binds can't capture the program's variables, and a template's
+// free symbols mean what they meant where the macro was defined, no matter
+// what's been bound where the macro is used.
+
+var aliasCount, macroCount uint64
+
+// makes a fresh alias of s for an expansion of the macro m.  An alias looks
+// like tmp{2:3}, where 2 is the macro's id and 3 sets the alias apart from
+// the ones made by other expansions.
+func (m *syntaxRules) alias(s symbol) symbol {
+	return symbol(fmt.Sprintf("%s{%d:%d}", s, m.id, atomic.AddUint64(&aliasCount, 1)))
+}
+
+// the symbol that the alias s was made from, and the id of the macro that
+// made it.  The last return value is false if s isn't an alias.
+func parseAlias(s symbol) (symbol, uint64, bool) {
+	str := string(s)
+	i := strings.LastIndexByte(str, '{')
+	if i <= 0 || !strings.HasSuffix(str, "}") {
+		return s, 0, false
+	}
+	ids := strings.SplitN(str[i+1:len(str)-1], ":", 2)
+	if len(ids) != 2 {
+		return s, 0, false
+	}
+	id, err := strconv.ParseUint(ids[0], 10, 64)
+	if err != nil {
+		return s, 0, false
+	}
+	if _, err := strconv.ParseUint(ids[1], 10, 64); err != nil {
+		return s, 0, false
+	}
+	return symbol(str[:i]), id, true
+}
+
+// the symbol that the alias s was made from.  The second return value is
+// false if s isn't an alias.
+func unalias(s symbol) (symbol, bool) {
+	orig, _, ok := parseAlias(s)
+	return orig, ok
+}
+
+// the symbol that s was written as in the source, undoing any renaming by
+// macros.
+func plain(s symbol) symbol {
+	for {
+		orig, ok := unalias(s)
+		if !ok {
+			return s
+		}
+		s = orig
+	}
+}
+
+// whether v is the keyword k, or an alias of it.  This is how special forms
+// recognize the words that are part of their syntax, like else, even when
+// they come out of a macro.
+func isKeyword(v interface{}, k symbol) bool {
+	s, ok := v.(symbol)
+	return ok && plain(s) == k
+}
+
+// copies the datum v, replacing aliases with the symbols that they were made
+// from.  This is how expansions are shown to people.  An alias is kept as it
+// is if v also has the symbol that it was made from, since showing them the
+// same way would hide that they're different.
+func unrename(v interface{}) interface{} {
+	taken := make(map[symbol]bool)
+	walkSymbols(v, func(s symbol) {
+		if _, ok := unalias(s); !ok {
+			taken[s] = true
+		}
+	})
+	return replaceSymbols(v, func(s symbol) symbol {
+		if p := plain(s); !taken[p] {
+			return p
+		}
+		return s
+	})
+}
+
+func walkSymbols(v interface{}, fn func(symbol)) {
+	switch t := v.(type) {
+	case symbol:
+		fn(t)
+	case *pair:
+		walkSymbols(t.Car, fn)
+		walkSymbols(t.Cdr, fn)
+	case *vector:
+		for _, item := range t.Items {
+			walkSymbols(item, fn)
+		}
+	}
+}
+
+func replaceSymbols(v interface{}, fn func(symbol) symbol) interface{} {
+	switch t := v.(type) {
+	case symbol:
+		return fn(t)
+	case *pair:
+		return &pair{Car: replaceSymbols(t.Car, fn), Cdr: replaceSymbols(t.Cdr, fn), Pos: t.Pos}
+	case *vector:
+		items := make([]interface{}, len(t.Items))
+		for i, item := range t.Items {
+			items[i] = replaceSymbols(item, fn)
+		}
+		return &vector{Items: items}
+	}
+	return v
+}
+
+// type syntaxRules is a macro made by syntax-rules.
+type syntaxRules struct {
+	name     string
+	id       uint64       // tells the macro's aliases apart from other macros' aliases
+	env      *environment // the environment that the macro was defined in
+	ellipsis symbol
+	literals []symbol
+	rules    []syntaxRule
+}
+
+// type syntaxRule is a rule of a syntax-rules macro.  The pattern leaves out
+// the macro keyword at the start of the form, which is never matched.
+type syntaxRule struct {
+	pattern  interface{}
+	template interface{}
+}
+
+func (m *syntaxRules) String() string {
+	if m.name == "" {
+		return "#<syntax>"
+	}
+	return "#<syntax " + m.name + ">"
+}
+
+func (m *syntaxRules) isEllipsis(v interface{}) bool {
+	return isKeyword(v, m.ellipsis)
+}
+
+func (m *syntaxRules) isLiteral(s symbol) bool {
+	for _, lit := range m.literals {
+		if plain(s) == plain(lit) {
+			return true
+		}
+	}
+	return false
+}
+
+// expands the form p, which is a use of the macro.
+func (m *syntaxRules) expand(p *pair) (interface{}, error) {
+	for _, r := range m.rules {
+		b := make(map[symbol]interface{})
+		if !m.match(r.pattern, p.Cdr, b) {
+			continue
+		}
+		x := expander{m, make(map[symbol]symbol)}
+		v, err := x.expand(r.template, b, templateState{})
+		if err != nil {
+			return nil, fmt.Errorf("in expansion of *%s*: %v", m.name, err)
+		}
+		if vp, ok := v.(*pair); ok && !vp.Pos.IsValid() {
+			vp.Pos = p.Pos
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("no rule of *%s* matches %v", m.name, repr(unrename(p)))
+}
+
+// expansions of the forms that have used macros, so that a macro used inside
+// of a loop is only expanded once.  An expansion is only reused if the form
+// still refers to the same macro.  Forms that are read in and evaluated once,
+// like the ones typed at the repl, would fill the cache up forever, so it's
+// emptied once it holds maxExpansions of them.
+const maxExpansions = 4096
+
+var expansions = struct {
+	sync.Mutex
+	m map[*pair]expansion
+}{m: make(map[*pair]expansion)}
+
+type expansion struct {
+	macro *syntaxRules
+	form  interface{}
+}
+
+// expands the form p, reusing the last expansion of p if there is one.
+func (m *syntaxRules) expandCached(p *pair) (interface{}, error) {
+	expansions.Lock()
+	e, ok := expansions.m[p]
+	expansions.Unlock()
+	if ok && e.macro == m {
+		return e.form, nil
+	}
+
+	v, err := m.expand(p)
+	if err != nil {
+		return nil, err
+	}
+	expansions.Lock()
+	if len(expansions.m) >= maxExpansions {
+		expansions.m = make(map[*pair]expansion)
+	}
+	expansions.m[p] = expansion{m, v}
+	expansions.Unlock()
+	return v, nil
+}
+
+// type ellipsisMatch holds what a pattern variable matched each time around
+// the ellipsis that follows it.
+type ellipsisMatch []interface{}
+
+// matches the input v against the pattern pat, storing what the pattern
+// variables matched in b.
+func (m *syntaxRules) match(pat, v interface{}, b map[symbol]interface{}) bool {
+	switch p := pat.(type) {
+	case symbol:
+		switch {
+		case plain(p) == "_":
+		case m.isLiteral(p):
+			s, ok := v.(symbol)
+			return ok && plain(s) == plain(p)
+		default:
+			b[p] = v
+		}
+		return true
+	case *pair:
+		pitems, ptail := splitList(p)
+		items, tail := splitList(v)
+		return m.matchItems(pitems, ptail, items, tail, b)
+	case emptyList:
+		return v == null
+	case *vector:
+		vec, ok := v.(*vector)
+		return ok && m.matchItems(p.Items, null, vec.Items, null, b)
+	}
+	return reader.Equal(pat, v)
+}
+
+// matches the items and tail of a list or vector against the items and tail
+// of a pattern.  At most one of the pattern's items is followed by an
+// ellipsis, which matches as many of the input's items as are left over.
+func (m *syntaxRules) matchItems(pitems []interface{}, ptail interface{}, items []interface{}, tail interface{}, b map[symbol]interface{}) bool {
+	e := -1
+	for i := 1; i < len(pitems); i++ {
+		if m.isEllipsis(pitems[i]) {
+			e = i - 1
+			break
+		}
+	}
+
+	if e < 0 {
+		if len(items) < len(pitems) || len(items) > len(pitems) && ptail == null {
+			return false
+		}
+		for i, p := range pitems {
+			if !m.match(p, items[i], b) {
+				return false
+			}
+		}
+		return m.match(ptail, reader.ListFrom(items[len(pitems):], tail), b)
+	}
+
+	pre, rep, post := pitems[:e], pitems[e], pitems[e+2:]
+	n := len(items) - len(pre) - len(post)
+	if n < 0 {
+		return false
+	}
+	for i, p := range pre {
+		if !m.match(p, items[i], b) {
+			return false
+		}
+	}
+	vars := m.patternVars(rep, nil)
+	seqs := make(map[symbol]ellipsisMatch, len(vars))
+	for _, s := range vars {
+		seqs[s] = ellipsisMatch{}
+	}
+	for _, item := range items[len(pre) : len(pre)+n] {
+		ib := make(map[symbol]interface{}, len(vars))
+		if !m.match(rep, item, ib) {
+			return false
+		}
+		for _, s := range vars {
+			seqs[s] = append(seqs[s], ib[s])
+		}
+	}
+	for s, seq := range seqs {
+		b[s] = seq
+	}
+	for i, p := range post {
+		if !m.match(p, items[len(pre)+n+i], b) {
+			return false
+		}
+	}
+	return m.match(ptail, tail, b)
+}
+
+// appends the pattern variables of the pattern pat to vars.
+func (m *syntaxRules) patternVars(pat interface{}, vars []symbol) []symbol {
+	switch p := pat.(type) {
+	case symbol:
+		if plain(p) != "_" && !m.isEllipsis(p) && !m.isLiteral(p) {
+			vars = append(vars, p)
+		}
+	case *pair:
+		vars = m.patternVars(p.Car, vars)
+		vars = m.patternVars(p.Cdr, vars)
+	case *vector:
+		for _, item := range p.Items {
+			vars = m.patternVars(item, vars)
+		}
+	}
+	return vars
+}
+
+// splits a list into its items and its tail, which is null for a proper
+// list.  Anything that isn't a pair is a list with no items.
+func splitList(v interface{}) ([]interface{}, interface{}) {
+	var items []interface{}
+	for {
+		p, ok := v.(*pair)
+		if !ok {
+			return items, v
+		}
+		items = append(items, p.Car)
+		v = p.Cdr
+	}
+}
+
+// checks that a pattern has at most one ellipsis in each list or vector, and
+// that each ellipsis follows something.
+func (m *syntaxRules) checkPattern(pat interface{}) error {
+	var items []interface{}
+	switch p := pat.(type) {
+	case *pair:
+		items, _ = splitList(p)
+	case *vector:
+		items = p.Items
+	default:
+		return nil
+	}
+	seen := false
+	for i, item := range items {
+		if !m.isEllipsis(item) {
+			if err := m.checkPattern(item); err != nil {
+				return err
+			}
+			continue
+		}
+		if i == 0 || seen {
+			return fmt.Errorf("misplaced %v in pattern %v", m.ellipsis, repr(pat))
+		}
+		seen = true
+	}
+	return nil
+}
+
+// type expander fills in the template of a rule.  renames holds the alias
+// that each symbol of the template has been renamed to so far, since each
+// symbol gets just one alias for the whole expansion.
+type expander struct {
+	m       *syntaxRules
+	renames map[symbol]symbol
+}
+
+// type templateState is where we are in a template.  Inside of a quote or
+// quasiquote, symbols are data, so they're left alone instead of renamed,
+// except inside of an unquote that gets back out of the quasiquote.  Inside
+// of (... template), ellipses are just symbols.
+type templateState struct {
+	quoted  bool
+	qq      int
+	escaped bool
+}
+
+// the state for the rest of a list that starts with head.
+func (st templateState) enter(head interface{}) templateState {
+	s, ok := head.(symbol)
+	if !ok {
+		return st
+	}
+	switch plain(s) {
+	case "quote":
+		if st.qq == 0 {
+			st.quoted = true
+		}
+	case "quasiquote":
+		if !st.quoted {
+			st.qq++
+		}
+	case "unquote", "unquote-splicing":
+		if !st.quoted && st.qq > 0 {
+			st.qq--
+		}
+	}
+	return st
+}
+
+func (x expander) expand(t interface{}, b map[symbol]interface{}, st templateState) (interface{}, error) {
+	switch v := t.(type) {
+	case symbol:
+		if val, ok := b[v]; ok {
+			if _, ok := val.(ellipsisMatch); ok {
+				return nil, fmt.Errorf("pattern variable %v is used with too few ellipses", plain(v))
+			}
+			return val, nil
+		}
+		if st.quoted || st.qq > 0 {
+			return plain(v), nil
+		}
+		a, ok := x.renames[v]
+		if !ok {
+			a = x.m.alias(v)
+			x.renames[v] = a
+		}
+		return a, nil
+	case *pair:
+		if !st.escaped && x.m.isEllipsis(v.Car) {
+			rest, ok := v.Cdr.(*pair)
+			if !ok || rest.Cdr != null {
+				return nil, fmt.Errorf("misplaced %v in template", x.m.ellipsis)
+			}
+			st.escaped = true
+			return x.expand(rest.Car, b, st)
+		}
+		items, tail := splitList(v)
+		inner := st.enter(items[0])
+		var out []interface{}
+		if len(items) > 1 && !st.escaped && x.m.isEllipsis(items[1]) {
+			vs, err := x.expandItems(items, b, st)
+			if err != nil {
+				return nil, err
+			}
+			out = vs
+		} else {
+			head, err := x.expand(items[0], b, st)
+			if err != nil {
+				return nil, err
+			}
+			rest, err := x.expandItems(items[1:], b, inner)
+			if err != nil {
+				return nil, err
+			}
+			out = append([]interface{}{head}, rest...)
+		}
+		rest, err := x.expand(tail, b, inner)
+		if err != nil {
+			return nil, err
+		}
+		return reader.ListFrom(out, rest), nil
+	case *vector:
+		st.quoted = true
+		items, err := x.expandItems(v.Items, b, st)
+		if err != nil {
+			return nil, err
+		}
+		return &vector{Items: items}, nil
+	}
+	return t, nil
+}
+
+// expands the items of a list or vector, repeating each item that's followed
+// by ellipses.
+func (x expander) expandItems(items []interface{}, b map[symbol]interface{}, st templateState) ([]interface{}, error) {
+	var out []interface{}
+	for i := 0; i < len(items); i++ {
+		item, depth := items[i], 0
+		for !st.escaped && i+1 < len(items) && x.m.isEllipsis(items[i+1]) {
+			depth++
+			i++
+		}
+		if depth == 0 {
+			v, err := x.expand(item, b, st)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+			continue
+		}
+		vs, err := x.repeat(item, b, depth, st)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vs...)
+	}
+	return out, nil
+}
+
+// expands the template t once for each item that its pattern variables
+// matched, for a template followed by depth ellipses.
+func (x expander) repeat(t interface{}, b map[symbol]interface{}, depth int, st templateState) ([]interface{}, error) {
+	var vars []symbol
+	for _, s := range x.m.patternVars(t, nil) {
+		if _, ok := b[s].(ellipsisMatch); ok {
+			vars = append(vars, s)
+		}
+	}
+	if len(vars) == 0 {
+		return nil, fmt.Errorf("no pattern variable in %v can be repeated by %v", repr(unrename(t)), x.m.ellipsis)
+	}
+	n := len(b[vars[0]].(ellipsisMatch))
+	for _, s := range vars[1:] {
+		if len(b[s].(ellipsisMatch)) != n {
+			return nil, fmt.Errorf("pattern variables %v and %v matched different numbers of items", plain(vars[0]), plain(s))
+		}
+	}
+
+	var out []interface{}
+	for i := 0; i < n; i++ {
+		ib := make(map[symbol]interface{}, len(b))
+		for s, v := range b {
+			ib[s] = v
+		}
+		for _, s := range vars {
+			ib[s] = b[s].(ellipsisMatch)[i]
+		}
+		if depth > 1 {
+			vs, err := x.repeat(t, ib, depth-1, st)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, vs...)
+			continue
+		}
+		v, err := x.expand(t, ib, st)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// defines the built-in "syntax-rules" construct, which makes a macro.  e.g.:
+//
+//	(define-syntax swap!
+//	  (syntax-rules ()
+//	    ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+//
+// defines swap! as a macro, so (swap! x y) is rewritten into the let
+// expression with x and y filled in for a and b, before it's evaluated.  The
+// first list holds literals, which are symbols that only match themselves,
+// like else in cond.  A pattern variable followed by ... matches any number
+// of items, and the template repeats whatever is followed by ... once for
+// each of them.  Another symbol can be used in place of ... by giving it
+// before the literals, as in (syntax-rules ::: () ...).  Since the macro is
+// hygienic, the tmp in the expansion of (swap! tmp y) is still the program's
+// tmp.
+var mksyntaxRules = &special{
+	name:     "syntax-rules",
+	arity:    1,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		m := &syntaxRules{env: env, ellipsis: "..."}
+		if s, ok := args[0].(symbol); ok {
+			m.ellipsis = plain(s)
+			args = args[1:]
+			if len(args) == 0 {
+				return nil, arityError{expected: 2, received: 1, name: "syntax-rules", variadic: true}
+			}
+		}
+
+		lits, err := listItems(args[0])
+		if err != nil {
+			return nil, fmt.Errorf(`literals of *syntax-rules* must be a list: %v`, err)
+		}
+		for _, lit := range lits {
+			s, ok := lit.(symbol)
+			if !ok {
+				return nil, fmt.Errorf(`literals of *syntax-rules* must be symbols, received %v`, reflect.TypeOf(lit))
+			}
+			m.literals = append(m.literals, s)
+		}
+
+		for _, arg := range args[1:] {
+			at := posOf(arg, position{})
+			items, err := listItems(arg)
+			if err != nil || len(items) != 2 {
+				return nil, errorAt(at, fmt.Errorf(`bad rule %v in *syntax-rules*, expected (pattern template)`, repr(arg)))
+			}
+			pat, ok := items[0].(*pair)
+			if !ok {
+				return nil, errorAt(at, fmt.Errorf(`bad rule %v in *syntax-rules*, pattern must be a list`, repr(arg)))
+			}
+			if err := m.checkPattern(pat.Cdr); err != nil {
+				return nil, errorAt(at, err)
+			}
+			m.rules = append(m.rules, syntaxRule{pat.Cdr, items[1]})
+		}
+		m.id = atomic.AddUint64(&macroCount, 1)
+		env.addMacro(m)
+		return m, nil
+	},
+}
+
+// checks that v is a macro.
+func macroArg(name string, v interface{}) (*syntaxRules, error) {
+	m, ok := v.(*syntaxRules)
+	if !ok {
+		return nil, fmt.Errorf(`*%s* expects a syntax-rules macro, received %v`, name, reflect.TypeOf(v))
+	}
+	return m, nil
+}
+
+// defines the built-in "define-syntax" construct, which binds a symbol to a
+// macro.  See syntax-rules for an example.
+var defineSyntax = &special{
+	name:  "define-syntax",
+	arity: 2,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		s, ok := args[0].(symbol)
+		if !ok {
+			return nil, fmt.Errorf(`first argument to *define-syntax* must be symbol, received %v`, reflect.TypeOf(args[0]))
+		}
+		return evalThen{args[1], env, func(v interface{}) (interface{}, error) {
+			m, err := macroArg("define-syntax", v)
+			if err != nil {
+				return nil, err
+			}
+			env.set(s, named(m, s))
+			return unspecified, nil
+		}}, nil
+	},
+}
+
+// defines the built-in "let-syntax" construct, which binds macros for the
+// duration of its body, the way that let binds values.  The macros are made
+// outside of the let-syntax, so they can't use each other.
+var letSyntax = &special{
+	name:     "let-syntax",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		return evalLetSyntax("let-syntax", env, newEnvironment(env), args)
+	},
+}
+
+// defines the built-in "letrec-syntax" construct, which is like let-syntax,
+// except that the macros are made inside of it, so they can use each other
+// and themselves.
+var letrecSyntax = &special{
+	name:     "letrec-syntax",
+	arity:    2,
+	variadic: true,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		inner := newEnvironment(env)
+		return evalLetSyntax("letrec-syntax", inner, inner, args)
+	},
+}
+
+// evaluates a let-syntax or letrec-syntax form, making its macros in outer
+// and binding them in inner.
+func evalLetSyntax(name string, outer, inner *environment, args []interface{}) (interface{}, error) {
+	bs, err := parseBindings(name, args[0], false, false)
+	if err != nil {
+		return nil, err
+	}
+	return evalArgs(bindingExprs(bs), outer, func(vals []interface{}) (interface{}, error) {
+		for i, b := range bs {
+			m, err := macroArg(name, vals[i])
+			if err != nil {
+				return nil, err
+			}
+			inner.set(b.name, named(m, b.name))
+		}
+		return evalLocal(inner, args[1:])
+	})
+}
+
+// expands the form v once, if it's a use of a macro in env.  The second
+// return value is whether it was.
+func expandOnce(v interface{}, env *environment) (interface{}, bool, error) {
+	p, ok := v.(*pair)
+	if !ok {
+		return v, false, nil
+	}
+	s, ok := p.Car.(symbol)
+	if !ok {
+		return v, false, nil
+	}
+	m, err := env.get(s)
+	if err != nil {
+		return v, false, nil
+	}
+	if m, ok := m.(*syntaxRules); ok {
+		x, err := m.expand(p)
+		return x, err == nil, err
+	}
+	return v, false, nil
+}
+
+// defines the built-in "macroexpand-1", which evaluates its argument and, if
+// that's a use of a macro, returns what it expands into.  Symbols renamed by
+// the expansion are shown as they were written, unless that would make them
+// look like symbols that they're not.  e.g.:
+//
+//	(macroexpand-1 (quote (swap! x y)))
+//
+// would evaluate to (let ((tmp x)) (set! x y) (set! y tmp)).  It's a special
+// form, rather than a builtin, because it needs to see the macros that are
+// bound where it's used.
+var macroexpand1 = &special{
+	name:  "macroexpand-1",
+	arity: 1,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		return evalThen{args[0], env, func(v interface{}) (interface{}, error) {
+			x, _, err := expandOnce(v, env)
+			if err != nil {
+				return nil, err
+			}
+			return unrename(x), nil
+		}}, nil
+	},
+}
+
+// defines the built-in "macroexpand", which is like macroexpand-1, except
+// that it keeps expanding until the form isn't a use of a macro anymore.  The
+// forms inside of the expansion aren't expanded.
+var macroexpand = &special{
+	name:  "macroexpand",
+	arity: 1,
+	fn: func(env *environment, args []interface{}) (interface{}, error) {
+		return evalThen{args[0], env, func(v interface{}) (interface{}, error) {
+			for {
+				x, expanded, err := expandOnce(v, env)
+				if err != nil {
+					return nil, err
+				}
+				if !expanded {
+					return unrename(x), nil
+				}
+				v = x
+			}
+		}}, nil
+	},
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSyntaxRules(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{"swap! with the user's own tmp", `
+			(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+			(define tmp 1)
+			(define other 2)
+			(swap! tmp other)
+			(list tmp other)`,
+			"(2 1)"},
+		{"else shadowed at the use site", `
+			(define-syntax my-if (syntax-rules () ((_ c a b) (cond (c a) (else b)))))
+			(let ((else #f)) (my-if #f 1 2))`,
+			"2"},
+		{"list shadowed at the use site", `
+			(define-syntax make-pair (syntax-rules () ((_ a b) (list a b))))
+			(let ((list vector)) (make-pair 1 2))`,
+			"(1 2)"},
+		{"free identifiers refer to where the macro was defined", `
+			(define x 5)
+			(define-syntax add-x (syntax-rules () ((_ y) (+ x y))))
+			(let ((x 100)) (add-x 1))`,
+			"6"},
+		{"ellipses", `
+			(define-syntax my-let (syntax-rules () ((_ ((n v) ...) body ...) ((lambda (n ...) body ...) v ...))))
+			(my-let ((a 1) (b 2)) (+ a b))`,
+			"3"},
+		{"nested ellipses", `
+			(define-syntax flip (syntax-rules () ((_ (a b ...) ...) '((b ... a) ...))))
+			(flip (1 2 3) (4 5) (6))`,
+			"((2 3 1) (5 4) (6))"},
+		{"literals", `
+			(define-syntax for (syntax-rules (in from) ((_ x in l) 'in) ((_ x from n) 'from)))
+			(list (for x in y) (for x from 1))`,
+			"(in from)"},
+		{"recursive macros", `
+			(define-syntax my-or (syntax-rules () ((_) #f) ((_ e) e) ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))
+			(define t 5)
+			(list (my-or) (my-or #f t) (my-or #f #f))`,
+			"(#f 5 #f)"},
+		{"redefining a macro after a procedure uses it", `
+			(define-syntax m (syntax-rules () ((_) 1)))
+			(define (f) (m))
+			(define before (f))
+			(define-syntax m (syntax-rules () ((_) 2)))
+			(list before (f))`,
+			"(1 2)"},
+		{"let-syntax", `
+			(define (double x) 'outer)
+			(let-syntax ((double (syntax-rules () ((_ x) (* x 2))))) (double 21))`,
+			"42"},
+		{"letrec-syntax", `
+			(letrec-syntax ((ev? (syntax-rules () ((_ n) (if (= n 0) #t (od? (- n 1))))))
+			                (od? (syntax-rules () ((_ n) (if (= n 0) #f #t)))))
+			  (list (ev? 0) (ev? 2)))`,
+			"(#t #t)"},
+		{"macroexpand-1", `
+			(define-syntax my-or2 (syntax-rules () ((_ a b) (if a a b))))
+			(define-syntax m2 (syntax-rules () ((_ x) (my-or2 x #f))))
+			(list (macroexpand-1 '(m2 q)) (macroexpand '(m2 q)))`,
+			"((my-or2 q #f) (if q q #f))"},
+		{"macroexpand-1 of a macro that binds a variable", `
+			(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+			(macroexpand-1 '(swap! x y))`,
+			"(let ((tmp x)) (set! x y) (set! y tmp))"},
+	})
+}
+
+func TestSyntaxRulesErrors(t *testing.T) {
+	runErrorTests(t, []evalTest{
+		{"a literal that doesn't match", `
+			(define-syntax for (syntax-rules (in) ((_ x in l) 'in)))
+			(for x to y)`,
+			"no rule of *for* matches (for x to y)"},
+		{"too few ellipses", `
+			(define-syntax bad (syntax-rules () ((_ a ...) a)))
+			(bad 1 2)`,
+			"pattern variable a is used with too few ellipses"},
+		{"misplaced ellipsis in a pattern", `
+			(define-syntax bad (syntax-rules () ((_ ... a) a)))`,
+			"misplaced ... in pattern"},
+		{"ellipsis with nothing to repeat", `
+			(define-syntax bad (syntax-rules () ((_ a) (a ...))))
+			(bad 1)`,
+			"can be repeated by ..."},
+		{"different numbers of items", `
+			(define-syntax zip (syntax-rules () ((_ (a ...) (b ...)) '((a b) ...))))
+			(zip (1 2) (3))`,
+			"matched different numbers of items"},
+		{"bad literals", `
+			(define-syntax bad (syntax-rules (1) ((_) 1)))`,
+			"literals of *syntax-rules* must be symbols"},
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"github.com/jordanorelli/skeam/cm"
 	"net"
 )
@@ -32,6 +33,7 @@ func startTCPConnection(conn net.Conn, m *cm.Manager) {
 	m.Add(conn)
 	defer m.Remove(conn)
 
-	i := newInterpreter(conn, conn, conn)
-	i.run(universe)
+	name := fmt.Sprintf("<tcp %v>", conn.RemoteAddr())
+	i := newInterpreter(name, conn, conn, conn)
+	i.repl(universe)
 }
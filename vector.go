@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jordanorelli/skeam/reader"
+	"reflect"
+)
+
+// type indexError is used when a vector is indexed with an index that is out
+// of its range.
+type indexError struct {
+	name   string
+	index  int64
+	length int
+}
+
+func (e indexError) Error() string {
+	return fmt.Sprintf(`index %d out of range in *%s*: vector has length %d`, e.index, e.name, e.length)
+}
+
+// checks the type of the vector argument to the builtin named name.
+func vectorArg(name string, v interface{}) (*vector, error) {
+	vec, ok := v.(*vector)
+	if !ok {
+		return nil, fmt.Errorf("*%s* expects a vector, received %v", name, reflect.TypeOf(v))
+	}
+	return vec, nil
+}
+
+// checks the type and range of the index argument to the builtin named name.
+func indexArg(name string, vec *vector, v interface{}) (int, error) {
+	i, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("*%s* expects an integer index, received %v", name, reflect.TypeOf(v))
+	}
+	if i < 0 || i >= int64(len(vec.Items)) {
+		return 0, indexError{name, i, len(vec.Items)}
+	}
+	return int(i), nil
+}
+
+var makeVector = &builtin{
+	name:     "make-vector",
+	arity:    1,
+	variadic: true,
+	fn: func(vals []interface{}) (interface{}, error) {
+		if len(vals) > 2 {
+			return nil, arityError{expected: 2, received: len(vals), name: "make-vector"}
+		}
+		n, ok := vals[0].(int64)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("*make-vector* expects a non-negative integer length, received %v", repr(vals[0]))
+		}
+		vec := &vector{Items: make([]interface{}, n)}
+		if len(vals) == 2 {
+			for i := range vec.Items {
+				vec.Items[i] = vals[1]
+			}
+		}
+		return vec, nil
+	},
+}
+
+var mkvector = &builtin{
+	name:     "vector",
+	variadic: true,
+	fn: func(vals []interface{}) (interface{}, error) {
+		items := make([]interface{}, len(vals))
+		copy(items, vals)
+		return &vector{Items: items}, nil
+	},
+}
+
+var isvector = &builtin{
+	name:  "vector?",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		_, ok := vals[0].(*vector)
+		return ok, nil
+	},
+}
+
+var vectorRef = &builtin{
+	name:  "vector-ref",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		vec, err := vectorArg("vector-ref", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		i, err := indexArg("vector-ref", vec, vals[1])
+		if err != nil {
+			return nil, err
+		}
+		return vec.Items[i], nil
+	},
+}
+
+var vectorSet = &builtin{
+	name:  "vector-set!",
+	arity: 3,
+	fn: func(vals []interface{}) (interface{}, error) {
+		vec, err := vectorArg("vector-set!", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		i, err := indexArg("vector-set!", vec, vals[1])
+		if err != nil {
+			return nil, err
+		}
+		vec.Items[i] = vals[2]
+		return unspecified, nil
+	},
+}
+
+var vectorLength = &builtin{
+	name:  "vector-length",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		vec, err := vectorArg("vector-length", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		return int64(len(vec.Items)), nil
+	},
+}
+
+var vectorToList = &builtin{
+	name:  "vector->list",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		vec, err := vectorArg("vector->list", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		return reader.ListFrom(vec.Items, null), nil
+	},
+}
+
+var listToVector = &builtin{
+	name:  "list->vector",
+	arity: 1,
+	fn: func(vals []interface{}) (interface{}, error) {
+		if !isList(vals[0]) {
+			return nil, fmt.Errorf("*list->vector* expects a proper list, received %v", repr(vals[0]))
+		}
+		items, _ := listItems(vals[0])
+		return &vector{Items: items}, nil
+	},
+}
+
+var vectorFill = &builtin{
+	name:  "vector-fill!",
+	arity: 2,
+	fn: func(vals []interface{}) (interface{}, error) {
+		vec, err := vectorArg("vector-fill!", vals[0])
+		if err != nil {
+			return nil, err
+		}
+		for i := range vec.Items {
+			vec.Items[i] = vals[1]
+		}
+		return unspecified, nil
+	},
+}
+
+// applies the procedure in vals[0] to the elements of the vectors in vals[1:],
+// element-wise, stopping at the end of the shortest vector.  The procedure is
+// called on the machine, one element at a time, and then done is called with
+// the number of elements and the results.  The results are kept in an
+// argList, so a continuation that comes back into the middle of the walk
+// doesn't see the results from after it was captured.
+func vectorWalk(name string, vals []interface{}, done func(int, *argList) (interface{}, error)) (interface{}, error) {
+	proc, ok := vals[0].(procedure)
+	if !ok {
+		return nil, fmt.Errorf("*%s* expects a procedure, received %v", name, reflect.TypeOf(vals[0]))
+	}
+	vecs := make([]*vector, len(vals)-1)
+	n := -1
+	for i, v := range vals[1:] {
+		vec, err := vectorArg(name, v)
+		if err != nil {
+			return nil, err
+		}
+		vecs[i] = vec
+		if n < 0 || len(vec.Items) < n {
+			n = len(vec.Items)
+		}
+	}
+	var step func(i int, results *argList) (interface{}, error)
+	step = func(i int, results *argList) (interface{}, error) {
+		if i == n {
+			return done(n, results)
+		}
+		args := make([]interface{}, len(vecs))
+		for j, vec := range vecs {
+			args[j] = vec.Items[i]
+		}
+		return applyThen{proc, args, func(v interface{}) (interface{}, error) {
+			return step(i+1, &argList{v, results})
+		}}, nil
+	}
+	return step(0, nil)
+}
+
+var vectorMap = &builtin{
+	name:     "vector-map",
+	arity:    2,
+	variadic: true,
+	fn: func(vals []interface{}) (interface{}, error) {
+		return vectorWalk("vector-map", vals, func(n int, results *argList) (interface{}, error) {
+			return &vector{Items: results.items(n)}, nil
+		})
+	},
+}
+
+var vectorForEach = &builtin{
+	name:     "vector-for-each",
+	arity:    2,
+	variadic: true,
+	fn: func(vals []interface{}) (interface{}, error) {
+		return vectorWalk("vector-for-each", vals, func(int, *argList) (interface{}, error) {
+			return unspecified, nil
+		})
+	},
+}